@@ -0,0 +1,231 @@
+package mmdbwriter
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// CapacityReport describes the size of the database that a tree would be
+// written as and whether it fits within the limits of the format.
+//
+// Each record in the search tree holds either a node number or, for data
+// records, the number of nodes plus 16 plus the offset of the record's
+// value in the data section. The largest such value must fit in a record.
+type CapacityReport struct {
+	// RecordSize is the configured record size in bits.
+	RecordSize int
+
+	// NodeCount is the number of nodes in the search tree.
+	NodeCount int
+
+	// SearchTreeSize is the size of the search tree in bytes.
+	SearchTreeSize int64
+
+	// DataSectionSize is the size of the data section in bytes, not
+	// including the separator that precedes it.
+	DataSectionSize int64
+
+	// MetadataSize is the size of the metadata section in bytes,
+	// including its start marker.
+	MetadataSize int64
+
+	// Size is the size of the database in bytes.
+	Size int64
+
+	// MaxRecordValue is the largest value that must be stored in a record.
+	MaxRecordValue int64
+
+	// RecordLimit is the largest value that a record of RecordSize bits
+	// may hold.
+	RecordLimit int64
+
+	// Overflow is the amount by which MaxRecordValue exceeds RecordLimit.
+	// It is zero if the database fits.
+	Overflow int64
+
+	// MinRecordSize is the smallest supported record size with which the
+	// database would fit, or zero if it would not fit with any.
+	MinRecordSize int
+
+	// Fields lists the number of bytes that each key of the values in the
+	// tree contributes to the data section, largest first. Values that
+	// are not maps, as well as the headers of maps, are attributed to the
+	// empty key. It is only set by Tree.Capacity.
+	Fields []FieldUsage
+}
+
+// FieldUsage is the number of bytes that a key contributes to the data
+// section, including the key itself and any pointers to previously written
+// data.
+type FieldUsage struct {
+	Key  string
+	Size int64
+}
+
+// Fits reports whether the database fits within the limits of the format.
+func (r *CapacityReport) Fits() bool {
+	return r.Overflow == 0
+}
+
+// CapacityError is returned by WriteTo when the tree would exceed the
+// limits of the format. The error is returned before anything is written.
+type CapacityError struct {
+	Report *CapacityReport
+}
+
+func (e *CapacityError) Error() string {
+	r := e.Report
+	msg := fmt.Sprintf(
+		"exceeded record capacity by %d: the largest record value is %d but a %d bit record may hold at most %d "+
+			"(%d nodes and a %d byte data section)",
+		r.Overflow,
+		r.MaxRecordValue,
+		r.RecordSize,
+		r.RecordLimit,
+		r.NodeCount,
+		r.DataSectionSize,
+	)
+	if r.MinRecordSize != 0 {
+		return fmt.Sprintf("%s; try increasing RecordSize to %d", msg, r.MinRecordSize)
+	}
+	return msg + "; reduce the size of the database, e.g., by removing the largest fields reported by Tree.Capacity"
+}
+
+// Capacity computes the exact layout of the database that the tree would
+// be written as without writing it. In addition to what WriteTo checks, it
+// reports how much each key contributes to the data section, which may be
+// used to decide what to remove from a database that does not fit. As the
+// data section is serialized to compute the report, this takes about as
+// long as writing the database. It is not threadsafe.
+func (t *Tree) Capacity() (*CapacityReport, error) {
+	if err := t.Finalize(); err != nil {
+		return nil, err
+	}
+
+	indexes, err := t.dataOrder()
+	if err != nil {
+		return nil, err
+	}
+
+	dataWriter := newDataWriter(t.dataMap, true)
+	dataWriter.keyOrder = t.mapKeyOrder
+	fw := &fieldWriter{dataWriter: dataWriter, sizes: map[string]int64{}}
+
+	maxOffset := 0
+	for _, idx := range indexes {
+		start := dataWriter.Len()
+		fw.attributed = 0
+
+		value := t.dataMap.get(idx)
+		var w dataTypeWriter = dataWriter
+		if _, ok := value.data.(mmdbtype.Map); ok {
+			w = fw
+		}
+		offset, err := dataWriter.maybeWriteTo(w, value)
+		if err != nil {
+			return nil, err
+		}
+		if offset > maxOffset {
+			maxOffset = offset
+		}
+		fw.sizes[""] += int64(dataWriter.Len()-start) - fw.attributed
+	}
+
+	metadataWriter := newDataWriter(t.dataMap, !t.disableMetadataPointers)
+	if _, err := t.writeMetadata(metadataWriter); err != nil {
+		return nil, fmt.Errorf("writing metadata: %w", err)
+	}
+
+	report := t.newCapacityReport(dataWriter.Len(), maxOffset, metadataWriter.Len())
+
+	for k, size := range fw.sizes {
+		if size != 0 {
+			report.Fields = append(report.Fields, FieldUsage{Key: k, Size: size})
+		}
+	}
+	sort.Slice(report.Fields, func(i, j int) bool {
+		a, b := report.Fields[i], report.Fields[j]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Key < b.Key
+	})
+
+	return report, nil
+}
+
+// newCapacityReport returns the report for a finalized tree whose data
+// section and metadata have the provided sizes and where the last value is
+// written at maxOffset.
+func (t *Tree) newCapacityReport(dataSectionSize, maxOffset, metadataSize int) *CapacityReport {
+	searchTreeSize := int64(t.nodeCount) * int64(t.recordSize) / 4
+	r := &CapacityReport{
+		RecordSize:      t.recordSize,
+		NodeCount:       t.nodeCount,
+		SearchTreeSize:  searchTreeSize,
+		DataSectionSize: int64(dataSectionSize),
+		MetadataSize:    int64(len(metadataStartMarker) + metadataSize),
+		RecordLimit:     recordLimit(t.recordSize),
+	}
+	r.Size = r.SearchTreeSize + int64(len(dataSectionSeparator)) + r.DataSectionSize + r.MetadataSize
+
+	// An empty record is stored as the node count.
+	r.MaxRecordValue = int64(t.nodeCount)
+	if dataSectionSize > 0 {
+		r.MaxRecordValue += int64(len(dataSectionSeparator) + maxOffset)
+	}
+
+	if r.MaxRecordValue > r.RecordLimit {
+		r.Overflow = r.MaxRecordValue - r.RecordLimit
+	}
+	for _, size := range []int{24, 28, 32} {
+		if r.MaxRecordValue <= recordLimit(size) {
+			r.MinRecordSize = size
+			break
+		}
+	}
+	return r
+}
+
+func recordLimit(recordSize int) int64 {
+	return 1<<recordSize - 1
+}
+
+// fieldWriter attributes the bytes written for each key of a map value to
+// that key. It only sees the keys and values of the outermost map as
+// anything nested within them is written directly to the dataWriter.
+type fieldWriter struct {
+	*dataWriter
+	sizes map[string]int64
+	// attributed is the number of bytes of the current value attributed to
+	// a key.
+	attributed int64
+
+	key     *mmdbtype.String
+	keySize int64
+}
+
+func (fw *fieldWriter) WriteOrWritePointer(t mmdbtype.DataType) (int64, error) {
+	n, err := fw.dataWriter.WriteOrWritePointer(t)
+	if err != nil {
+		return n, err
+	}
+
+	// Map.WriteTo writes each key followed by its value.
+	if fw.key == nil {
+		k, ok := t.(mmdbtype.String)
+		if !ok {
+			return n, fmt.Errorf("unexpected map key type %T", t)
+		}
+		fw.key = &k
+		fw.keySize = n
+		return n, nil
+	}
+
+	fw.sizes[string(*fw.key)] += fw.keySize + n
+	fw.attributed += fw.keySize + n
+	fw.key = nil
+	return n, nil
+}
@@ -0,0 +1,85 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapacity(t *testing.T) {
+	tree, err := New(
+		Options{
+			DatabaseType: "mmdbwriter-test",
+			Description:  map[string]string{"en": "Test database"},
+			RecordSize:   24,
+		},
+	)
+	require.NoError(t, err)
+
+	insert := func(cidr string, value mmdbtype.DataType) {
+		_, network, err := net.ParseCIDR(cidr)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(network, value))
+	}
+	insert("1.0.0.0/24", mmdbtype.Map{
+		"country": mmdbtype.String("DE"),
+		"notes":   mmdbtype.String(strings.Repeat("x", 1000)),
+	})
+	insert("2.0.0.0/24", mmdbtype.Map{
+		"country": mmdbtype.String("DE"),
+		"notes":   mmdbtype.String(strings.Repeat("y", 1000)),
+	})
+	insert("3.0.0.0/24", mmdbtype.Uint32(1))
+
+	report, err := tree.Capacity()
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	_, err = tree.WriteTo(buf)
+	require.NoError(t, err)
+
+	assert.True(t, report.Fits())
+	assert.Equal(t, 24, report.MinRecordSize)
+	assert.Equal(t, int64(buf.Len()), report.Size)
+	assert.Equal(t, int64(report.NodeCount*24/4), report.SearchTreeSize)
+
+	var total int64
+	for _, f := range report.Fields {
+		total += f.Size
+	}
+	assert.Equal(t, report.DataSectionSize, total, "all bytes are attributed")
+	require.NotEmpty(t, report.Fields)
+	assert.Equal(t, "notes", report.Fields[0].Key)
+
+	// Each value is too large to be deduplicated and together they exceed
+	// what a 24 bit record may refer to.
+	for i := 0; i < 20; i++ {
+		insert(
+			net.IPv4(4, byte(i), 0, 0).String()+"/16",
+			mmdbtype.Map{"blob": mmdbtype.Bytes(bytes.Repeat([]byte{byte(i)}, 1<<20))},
+		)
+	}
+
+	buf.Reset()
+	_, err = tree.WriteTo(buf)
+	var capacityErr *CapacityError
+	require.True(t, errors.As(err, &capacityErr), "error is a CapacityError")
+	assert.Zero(t, buf.Len(), "nothing is written")
+
+	r := capacityErr.Report
+	assert.False(t, r.Fits())
+	assert.Equal(t, r.MaxRecordValue-r.RecordLimit, r.Overflow)
+	assert.Equal(t, 28, r.MinRecordSize)
+	assert.Contains(t, err.Error(), "try increasing RecordSize to 28")
+
+	report, err = tree.Capacity()
+	require.NoError(t, err)
+	assert.Equal(t, r.Overflow, report.Overflow)
+	assert.Equal(t, "blob", report.Fields[0].Key)
+}
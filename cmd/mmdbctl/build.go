@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/csvimport"
+)
+
+var buildCommand = &command{
+	name:    "build",
+	usage:   "-spec spec.json -o out.mmdb [flags] file.csv...",
+	summary: "Build a database from CSV files using a column mapping spec.",
+}
+
+func init() {
+	buildCommand.run = runBuild
+}
+
+func runBuild(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet(buildCommand, stderr)
+	var tf treeFlags
+	tf.register(fs, "replace")
+	specPath := fs.String("spec", "", "JSON file mapping the columns of the CSV files to networks and data")
+	out := fs.String("o", "", "path of the database to write")
+	checksum := fs.Bool("checksum", false, "also write a sha256sum file next to the database")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	switch {
+	case *specPath == "":
+		return usageError("-spec is required")
+	case *out == "":
+		return usageError("-o is required")
+	case fs.NArg() == 0:
+		return usageError("at least one CSV file is required")
+	}
+
+	spec, err := csvimport.LoadSpec(*specPath)
+	if err != nil {
+		return err
+	}
+
+	opts, err := tf.options()
+	if err != nil {
+		return err
+	}
+	tree, err := mmdbwriter.New(opts)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range fs.Args() {
+		n, err := csvimport.InsertFile(tree, path, spec, opts.Inserter)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s: inserted %d rows\n", path, n)
+	}
+
+	return writeTree(tree, *out, *checksum, stdout)
+}
+
+// writeTree writes the tree to path and reports its digest.
+func writeTree(tree *mmdbwriter.Tree, path string, checksum bool, stdout io.Writer) error {
+	var opts []mmdbwriter.WriteFileOption
+	if checksum {
+		opts = append(opts, mmdbwriter.WithChecksumFile())
+	}
+	digest, err := tree.WriteFile(path, 0o644, opts...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "wrote %s (sha256 %x)\n", path, digest)
+	return nil
+}
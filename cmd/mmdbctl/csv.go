@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter/csvexport"
+)
+
+var csvCommand = &command{
+	name:  "csv",
+	usage: "[-layout geolite2|flat] -o out [-prefix name] [-locale list] [-cidr network] db.mmdb",
+	summary: "Write the networks of a database as CSV. The geolite2 layout writes blocks files and a\n" +
+		"locations file per locale to the -o directory, like the GeoLite2 City CSV database. The flat\n" +
+		"layout writes a single file with a column per value.",
+}
+
+func init() {
+	csvCommand.run = runCSV
+}
+
+func runCSV(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet(csvCommand, stderr)
+	layout := fs.String("layout", "geolite2", "layout of the output: geolite2 or flat")
+	out := fs.String("o", "", "directory to write for the geolite2 layout or file to write for the flat layout")
+	prefix := fs.String("prefix", "", "prefix of the names of the geolite2 files (default the database name)")
+	var locales listFlag
+	fs.Var(&locales, "locale", "locale of a geolite2 locations file (repeatable or comma-separated; default all)")
+	cidr := fs.String("cidr", "", "only write the networks within this network")
+	var lf loadFlags
+	lf.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	switch {
+	case *layout != "geolite2" && *layout != "flat":
+		return usageError(fmt.Sprintf("unknown layout %q", *layout))
+	case *layout == "geolite2" && *out == "":
+		return usageError("-o is required for the geolite2 layout")
+	case fs.NArg() != 1:
+		return usageError("exactly one database is required")
+	}
+
+	opts := csvexport.Options{Locales: locales}
+	if *cidr != "" {
+		_, network, err := net.ParseCIDR(*cidr)
+		if err != nil {
+			return usageError(fmt.Sprintf("invalid -cidr: %v", err))
+		}
+		opts.Network = network
+	}
+
+	path := fs.Arg(0)
+	tree, err := lf.loadTree(path)
+	if err != nil {
+		return err
+	}
+
+	if *layout == "flat" {
+		if *out == "" {
+			_, err := csvexport.WriteFlat(stdout, tree, opts)
+			return err
+		}
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		n, err := csvexport.WriteFlat(f, tree, opts)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "wrote %d networks to %s\n", n, *out)
+		return nil
+	}
+
+	if *prefix == "" {
+		*prefix = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if err := os.MkdirAll(*out, 0o755); err != nil { //nolint:gosec // the files are not secret
+		return err
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	create := func(name string) (io.Writer, error) {
+		f, err := os.Create(filepath.Join(*out, *prefix+"-"+name))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		return f, nil
+	}
+
+	n, err := csvexport.WriteGeoLite2(create, tree, opts)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "wrote %s\n", f.Name())
+	}
+	files = nil
+	fmt.Fprintf(stdout, "wrote %d networks\n", n)
+	return nil
+}
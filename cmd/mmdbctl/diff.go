@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/jsonl"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"go4.org/netipx"
+)
+
+var diffCommand = &command{
+	name:  "diff",
+	usage: "[-format text|jsonl] [-summary] old.mmdb new.mmdb",
+	summary: "Print the networks whose data differ between two databases, the paths that were added,\n" +
+		"removed, or changed in each, and the number of networks changed per key.",
+}
+
+func init() {
+	diffCommand.run = runDiff
+}
+
+func runDiff(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet(diffCommand, stderr)
+	format := fs.String("format", "text", "output format: text or jsonl")
+	summaryOnly := fs.Bool("summary", false, "only print the summary")
+	var lf loadFlags
+	lf.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return usageError("exactly two databases are required")
+	}
+
+	var out diffWriter
+	switch *format {
+	case "text":
+		out = &textDiffWriter{summaryOnly: *summaryOnly}
+	case "jsonl":
+		out = &jsonlDiffWriter{summaryOnly: *summaryOnly}
+	default:
+		return usageError(fmt.Sprintf("unknown format %q", *format))
+	}
+
+	var ranges [2][]dataRange
+	for i, path := range fs.Args() {
+		tree, err := lf.loadTree(path)
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", path, err)
+		}
+		if ranges[i], err = treeRanges(tree); err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+	}
+
+	w := bufio.NewWriter(stdout)
+	summary := newDiffSummary()
+	err := diffRanges(ranges[0], ranges[1], func(d *networkDiff) error {
+		summary.add(d)
+		return out.writeNetworks(w, d)
+	})
+	if err != nil {
+		return err
+	}
+	if err := out.writeSummary(w, summary); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// dataRange is a range of IPv6 addresses with the same data. IPv4
+// addresses are mapped into ::/96.
+type dataRange struct {
+	netipx.IPRange
+	data mmdbtype.DataType
+}
+
+// treeRanges returns the networks with data in the tree as ranges in
+// order.
+func treeRanges(tree *mmdbwriter.Tree) ([]dataRange, error) {
+	var ranges []dataRange
+	err := tree.Networks(func(network *net.IPNet, value mmdbtype.DataType) error {
+		prefix, ok := netipx.FromStdIPNet(network)
+		if !ok {
+			return fmt.Errorf("invalid network %s", network)
+		}
+		if prefix.Addr().Is4() {
+			a := prefix.Addr().As4()
+			var b [16]byte
+			copy(b[12:], a[:])
+			prefix = netip.PrefixFrom(netip.AddrFrom16(b), prefix.Bits()+96)
+		}
+		ranges = append(ranges, dataRange{IPRange: netipx.RangeOfPrefix(prefix), data: value})
+		return nil
+	})
+	return ranges, err
+}
+
+// networkDiff describes the changes to adjacent networks with the same
+// changes.
+type networkDiff struct {
+	prefixes []netip.Prefix
+	// kind is changeAdded if the networks had no data in the old database,
+	// changeRemoved if they have no data in the new one, and changeChanged
+	// otherwise.
+	kind    changeKind
+	changes []change
+}
+
+// maxAddr is the last IPv6 address.
+var maxAddr = netip.MustParseAddr("ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff")
+
+// diffRanges calls fn with the networks whose data differ between the
+// ordered ranges in a and b.
+func diffRanges(a, b []dataRange, fn func(*networkDiff) error) error {
+	pos := netip.IPv6Unspecified()
+	for {
+		for len(a) > 0 && a[0].To().Less(pos) {
+			a = a[1:]
+		}
+		for len(b) > 0 && b[0].To().Less(pos) {
+			b = b[1:]
+		}
+		if len(a) == 0 && len(b) == 0 {
+			return nil
+		}
+
+		aActive := len(a) > 0 && !pos.Less(a[0].From())
+		bActive := len(b) > 0 && !pos.Less(b[0].From())
+		if !aActive && !bActive {
+			pos = nextStart(a, b)
+			continue
+		}
+
+		// The segment starting at pos ends where either side next
+		// changes.
+		end := maxAddr
+		var old, updated mmdbtype.DataType
+		if aActive {
+			old = a[0].data
+			end = minAddr(end, a[0].To())
+		} else if len(a) > 0 {
+			end = minAddr(end, a[0].From().Prev())
+		}
+		if bActive {
+			updated = b[0].data
+			end = minAddr(end, b[0].To())
+		} else if len(b) > 0 {
+			end = minAddr(end, b[0].From().Prev())
+		}
+
+		if changes := diffValues(nil, old, updated, nil); len(changes) > 0 {
+			d := &networkDiff{
+				prefixes: netipx.IPRangeFrom(pos, end).Prefixes(),
+				kind:     changeChanged,
+				changes:  changes,
+			}
+			for i, prefix := range d.prefixes {
+				d.prefixes[i] = unmapPrefix(prefix)
+			}
+			switch {
+			case old == nil:
+				d.kind = changeAdded
+			case updated == nil:
+				d.kind = changeRemoved
+			}
+			if err := fn(d); err != nil {
+				return err
+			}
+		}
+
+		pos = end.Next()
+		if !pos.IsValid() {
+			return nil
+		}
+	}
+}
+
+func nextStart(a, b []dataRange) netip.Addr {
+	switch {
+	case len(a) == 0:
+		return b[0].From()
+	case len(b) == 0:
+		return a[0].From()
+	default:
+		return minAddr(a[0].From(), b[0].From())
+	}
+}
+
+func minAddr(a, b netip.Addr) netip.Addr {
+	if b.Less(a) {
+		return b
+	}
+	return a
+}
+
+// unmapPrefix returns prefixes within ::/96 as IPv4 prefixes.
+func unmapPrefix(prefix netip.Prefix) netip.Prefix {
+	b := prefix.Addr().As16()
+	if prefix.Bits() < 96 || b != [16]byte{12: b[12], 13: b[13], 14: b[14], 15: b[15]} {
+		return prefix
+	}
+	return netip.PrefixFrom(netip.AddrFrom4([4]byte{b[12], b[13], b[14], b[15]}), prefix.Bits()-96)
+}
+
+// changeKind is the kind of a change to a value.
+type changeKind string
+
+const (
+	changeAdded   changeKind = "added"
+	changeRemoved changeKind = "removed"
+	changeChanged changeKind = "changed"
+)
+
+// change is a difference between the data of a network in two databases.
+type change struct {
+	// path is the path of the value in the data, e.g., country.iso_code.
+	// Arrays are compared as a whole.
+	path []string
+	kind changeKind
+	old  mmdbtype.DataType
+	new  mmdbtype.DataType
+}
+
+func (c change) pathString() string {
+	if len(c.path) == 0 {
+		return "(record)"
+	}
+	return strings.Join(c.path, ".")
+}
+
+// diffValues appends the changes between old and updated, which are at
+// path, to changes. Maps are compared key by key and other values as a
+// whole.
+func diffValues(path []string, old, updated mmdbtype.DataType, changes []change) []change {
+	switch {
+	case old == nil && updated == nil:
+		return changes
+	case old == nil:
+		if m, ok := updated.(mmdbtype.Map); ok && len(m) > 0 {
+			return diffMaps(path, nil, m, changes)
+		}
+		return append(changes, change{path: path, kind: changeAdded, new: updated})
+	case updated == nil:
+		if m, ok := old.(mmdbtype.Map); ok && len(m) > 0 {
+			return diffMaps(path, m, nil, changes)
+		}
+		return append(changes, change{path: path, kind: changeRemoved, old: old})
+	}
+
+	oldMap, oldIsMap := old.(mmdbtype.Map)
+	updatedMap, updatedIsMap := updated.(mmdbtype.Map)
+	if oldIsMap && updatedIsMap {
+		return diffMaps(path, oldMap, updatedMap, changes)
+	}
+	if old.Equal(updated) {
+		return changes
+	}
+	return append(changes, change{path: path, kind: changeChanged, old: old, new: updated})
+}
+
+func diffMaps(path []string, old, updated mmdbtype.Map, changes []change) []change {
+	keys := make([]string, 0, len(old)+len(updated))
+	for k := range old {
+		keys = append(keys, string(k))
+	}
+	for k := range updated {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, string(k))
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		// The path is copied as the changes retain it.
+		keyPath := append(path[:len(path):len(path)], k)
+		changes = diffValues(keyPath, old[mmdbtype.String(k)], updated[mmdbtype.String(k)], changes)
+	}
+	return changes
+}
+
+// diffSummary counts the networks with changes.
+type diffSummary struct {
+	networks map[changeKind]int
+	// keys counts the networks with changes within each top-level key.
+	keys map[string]int
+	// paths counts the networks with each kind of change to each path.
+	paths map[string]map[changeKind]int
+}
+
+func newDiffSummary() *diffSummary {
+	return &diffSummary{
+		networks: map[changeKind]int{},
+		keys:     map[string]int{},
+		paths:    map[string]map[changeKind]int{},
+	}
+}
+
+// add adds the networks to the summary.
+func (s *diffSummary) add(d *networkDiff) {
+	n := len(d.prefixes)
+	s.networks[d.kind] += n
+
+	seen := map[string]bool{}
+	for _, c := range d.changes {
+		key := c.pathString()
+		if len(c.path) > 0 {
+			key = c.path[0]
+		}
+		if !seen[key] {
+			seen[key] = true
+			s.keys[key] += n
+		}
+
+		path := c.pathString()
+		if s.paths[path] == nil {
+			s.paths[path] = map[changeKind]int{}
+		}
+		s.paths[path][c.kind] += n
+	}
+}
+
+func pluralize(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
+
+// countsString formats counts such as "3 changed, 1 added".
+func countsString(counts map[changeKind]int) string {
+	var parts []string
+	for _, kind := range []changeKind{changeChanged, changeAdded, changeRemoved} {
+		if n := counts[kind]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, kind))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// diffWriter writes the output of diff.
+type diffWriter interface {
+	writeNetworks(w *bufio.Writer, d *networkDiff) error
+	writeSummary(w *bufio.Writer, s *diffSummary) error
+}
+
+// textDiffWriter writes a human-readable diff.
+type textDiffWriter struct {
+	summaryOnly bool
+}
+
+func (dw *textDiffWriter) writeNetworks(w *bufio.Writer, d *networkDiff) error {
+	if dw.summaryOnly {
+		return nil
+	}
+	for _, prefix := range d.prefixes {
+		fmt.Fprintf(w, "%s\n", prefix)
+	}
+	for _, c := range d.changes {
+		var err error
+		switch c.kind {
+		case changeAdded:
+			err = writeChangeLine(w, "+", c.pathString(), c.new)
+		case changeRemoved:
+			err = writeChangeLine(w, "-", c.pathString(), c.old)
+		default:
+			err = writeChangeLine(w, "~", c.pathString(), c.old, c.new)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeChangeLine(w *bufio.Writer, marker, path string, values ...mmdbtype.DataType) error {
+	formatted := make([]string, len(values))
+	for i, v := range values {
+		b, err := jsonl.MarshalValue(v, false)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		formatted[i] = string(b)
+	}
+	_, err := fmt.Fprintf(w, "  %s %s: %s\n", marker, path, strings.Join(formatted, " -> "))
+	return err
+}
+
+func (dw *textDiffWriter) writeSummary(w *bufio.Writer, s *diffSummary) error {
+	if !dw.summaryOnly && len(s.networks) > 0 {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(
+		w,
+		"%s added, %s removed, %s changed\n",
+		pluralize(s.networks[changeAdded], "network"),
+		pluralize(s.networks[changeRemoved], "network"),
+		pluralize(s.networks[changeChanged], "network"),
+	)
+	for _, key := range sortedKeys(s.keys) {
+		fmt.Fprintf(w, "  %s: %s", key, pluralize(s.keys[key], "network"))
+		if counts, ok := s.paths[key]; ok {
+			fmt.Fprintf(w, " (%s)", countsString(counts))
+		}
+		fmt.Fprintln(w)
+		for _, path := range sortedKeys(s.paths) {
+			if strings.HasPrefix(path, key+".") {
+				fmt.Fprintf(w, "    %s: %s\n", path, countsString(s.paths[path]))
+			}
+		}
+	}
+	return nil
+}
+
+// jsonlDiffWriter writes a JSON object for each network with changes
+// followed by one with the summary.
+type jsonlDiffWriter struct {
+	summaryOnly bool
+}
+
+type jsonChange struct {
+	Path string          `json:"path"`
+	Kind changeKind      `json:"kind"`
+	Old  json.RawMessage `json:"old,omitempty"`
+	New  json.RawMessage `json:"new,omitempty"`
+}
+
+type jsonDiffSummary struct {
+	Added   int                           `json:"added"`
+	Removed int                           `json:"removed"`
+	Changed int                           `json:"changed"`
+	Keys    map[string]int                `json:"keys"`
+	Paths   map[string]map[changeKind]int `json:"paths"`
+}
+
+func (dw *jsonlDiffWriter) writeNetworks(w *bufio.Writer, d *networkDiff) error {
+	if dw.summaryOnly {
+		return nil
+	}
+
+	jsonChanges := make([]jsonChange, len(d.changes))
+	for i, c := range d.changes {
+		jc := jsonChange{Path: c.pathString(), Kind: c.kind}
+		var err error
+		if c.old != nil {
+			if jc.Old, err = jsonl.MarshalValue(c.old, false); err != nil {
+				return fmt.Errorf("%s: %w", jc.Path, err)
+			}
+		}
+		if c.new != nil {
+			if jc.New, err = jsonl.MarshalValue(c.new, false); err != nil {
+				return fmt.Errorf("%s: %w", jc.Path, err)
+			}
+		}
+		jsonChanges[i] = jc
+	}
+
+	enc := newJSONEncoder(w)
+	for _, prefix := range d.prefixes {
+		err := enc.Encode(struct {
+			Network string       `json:"network"`
+			Changes []jsonChange `json:"changes"`
+		}{prefix.String(), jsonChanges})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dw *jsonlDiffWriter) writeSummary(w *bufio.Writer, s *diffSummary) error {
+	return newJSONEncoder(w).Encode(struct {
+		Summary jsonDiffSummary `json:"summary"`
+	}{jsonDiffSummary{
+		Added:   s.networks[changeAdded],
+		Removed: s.networks[changeRemoved],
+		Changed: s.networks[changeChanged],
+		Keys:    s.keys,
+		Paths:   s.paths,
+	}})
+}
+
+func newJSONEncoder(w io.Writer) *json.Encoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc
+}
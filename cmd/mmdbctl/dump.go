@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/maxmind/mmdbwriter/jsonl"
+)
+
+var dumpCommand = &command{
+	name:    "dump",
+	usage:   "[-typed] [-aliased] [-cidr network] [-o out.jsonl] db.mmdb",
+	summary: "Write each network in a database and its data as JSON Lines.",
+}
+
+func init() {
+	dumpCommand.run = runDump
+}
+
+func runDump(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet(dumpCommand, stderr)
+	typed := fs.Bool("typed", false, "write typed JSON, which preserves the type of each value")
+	aliased := fs.Bool("aliased", false, "also write the IPv6 networks aliased to the IPv4 networks")
+	cidr := fs.String("cidr", "", "only write the networks within this network")
+	out := fs.String("o", "", "path of the file to write (default stdout)")
+	var lf loadFlags
+	lf.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("exactly one database is required")
+	}
+
+	opts := jsonl.DumpOptions{
+		IncludeAliasedNetworks: *aliased,
+		Typed:                  *typed,
+	}
+	if *cidr != "" {
+		_, network, err := net.ParseCIDR(*cidr)
+		if err != nil {
+			return usageError(fmt.Sprintf("invalid -cidr: %v", err))
+		}
+		opts.Network = network
+	}
+
+	tree, err := lf.loadTree(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err := jsonl.Dump(stdout, tree, opts)
+		return err
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if _, err := jsonl.Dump(f, tree, opts); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/csvimport"
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/jsonl"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"go4.org/netipx"
+)
+
+var enrichCommand = &command{
+	name:  "enrich",
+	usage: "-o out.mmdb [-spec spec.json] [-typed | -schema schema.json] [flags] base.mmdb patch...",
+	summary: "Apply CSV or JSON Lines patch files to an existing database. Patches ending in .csv are\n" +
+		"read using -spec and the others are read as JSON Lines.",
+}
+
+func init() {
+	enrichCommand.run = runEnrich
+}
+
+func runEnrich(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet(enrichCommand, stderr)
+	var tf treeFlags
+	tf.register(fs, "deep-merge")
+	specPath := fs.String("spec", "", "JSON file mapping the columns of CSV patches to networks and data")
+	typed := fs.Bool("typed", false, "read JSON Lines patches as typed JSON as written by dump -typed")
+	schemaPath := fs.String("schema", "", "JSON file setting the types of values in plain JSON Lines patches")
+	onlyEnglish := fs.Bool("only-english-names", false, "drop the names in languages other than English from the base")
+	dropRegistered := fs.Bool("drop-registered-country", false, "drop registered_country from the base")
+	out := fs.String("o", "", "path of the database to write")
+	checksum := fs.Bool("checksum", false, "also write a sha256sum file next to the database")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	switch {
+	case *out == "":
+		return usageError("-o is required")
+	case *typed && *schemaPath != "":
+		return usageError("-schema may not be used with -typed")
+	case fs.NArg() < 2:
+		return usageError("a base database and at least one patch are required")
+	}
+
+	p := &patcher{typed: *typed}
+	var err error
+	if *specPath != "" {
+		if p.spec, err = csvimport.LoadSpec(*specPath); err != nil {
+			return err
+		}
+	}
+	if *schemaPath != "" {
+		if p.schema, err = jsonl.LoadSchema(*schemaPath); err != nil {
+			return err
+		}
+	}
+
+	opts, err := tf.options()
+	if err != nil {
+		return err
+	}
+	opts.OnlyEn = *onlyEnglish
+	opts.DelRegCountry = *dropRegistered
+	// Load inserts the networks of the base database using the tree's
+	// inserter, so the patches are applied with an explicit one instead.
+	p.inserter = opts.Inserter
+	opts.Inserter = nil
+
+	base := fs.Arg(0)
+	p.tree, err = mmdbwriter.Load(base, opts)
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", base, err)
+	}
+
+	var total patchStats
+	for _, path := range fs.Args()[1:] {
+		stats, err := p.apply(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Fprintf(stdout, "%s: %s\n", path, stats)
+		total.add(stats)
+	}
+	fmt.Fprintf(stdout, "total: %s\n", total)
+
+	return writeTree(p.tree, *out, *checksum, stdout)
+}
+
+// patchStats counts the changes made by a patch.
+type patchStats struct {
+	// records is the number of records read from the patch.
+	records int
+	// touched is the number of networks in the tree passed to the
+	// inserter, i.e., the networks that were inserted or updated.
+	touched int
+	// splits is the number of networks with data in the tree that had to
+	// be split as a record was inserted into only a part of them.
+	splits int
+}
+
+func (s *patchStats) add(o patchStats) {
+	s.records += o.records
+	s.touched += o.touched
+	s.splits += o.splits
+}
+
+func (s patchStats) String() string {
+	return fmt.Sprintf("applied %d records, touched %d networks, split %d networks", s.records, s.touched, s.splits)
+}
+
+// patcher applies patch files to a tree.
+type patcher struct {
+	tree     *mmdbwriter.Tree
+	inserter inserter.FuncGenerator
+	spec     *csvimport.Spec
+	typed    bool
+	schema   *jsonl.Schema
+}
+
+// patchRecord is a record read from a patch. Either network or both start
+// and end are set.
+type patchRecord struct {
+	network *net.IPNet
+	start   net.IP
+	end     net.IP
+	data    mmdbtype.DataType
+}
+
+func (p *patcher) apply(path string) (patchStats, error) {
+	f, err := os.Open(path) //nolint:gosec // reading the patch is the point
+	if err != nil {
+		return patchStats{}, err
+	}
+	defer f.Close()
+
+	var next func() (*patchRecord, error)
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		if p.spec == nil {
+			return patchStats{}, usageError("-spec is required for CSV patches")
+		}
+		r, err := csvimport.NewReader(f, p.spec)
+		if err != nil {
+			return patchStats{}, err
+		}
+		next = func() (*patchRecord, error) {
+			row, err := r.Read()
+			if err != nil {
+				return nil, err
+			}
+			return &patchRecord{
+				network: row.Network,
+				start:   row.Start,
+				end:     row.End,
+				data:    row.Data,
+			}, nil
+		}
+	} else {
+		r := jsonl.NewReader(f)
+		r.Typed = p.typed
+		r.Schema = p.schema
+		next = func() (*patchRecord, error) {
+			record, err := r.Read()
+			if err != nil {
+				return nil, err
+			}
+			return &patchRecord{
+				network: record.Network,
+				start:   record.Start,
+				end:     record.End,
+				data:    record.Data,
+			}, nil
+		}
+	}
+
+	var stats patchStats
+	counting := func(value mmdbtype.DataType) inserter.Func {
+		f := p.inserter(value)
+		return func(existing mmdbtype.DataType) (mmdbtype.DataType, error) {
+			stats.touched++
+			return f(existing)
+		}
+	}
+
+	for {
+		record, err := next()
+		if errors.Is(err, io.EOF) {
+			return stats, nil
+		}
+		if err != nil {
+			return stats, err
+		}
+
+		ipRange, err := record.ipRange()
+		if err != nil {
+			return stats, err
+		}
+		stats.splits += p.splits(ipRange)
+		for _, network := range record.networks(ipRange) {
+			if err := p.tree.InsertFunc(network, counting(record.data)); err != nil {
+				return stats, fmt.Errorf("inserting %s: %w", network, err)
+			}
+		}
+		stats.records++
+	}
+}
+
+// ipRange returns the range of addresses covered by the record.
+func (r *patchRecord) ipRange() (netipx.IPRange, error) {
+	if r.network != nil {
+		prefix, ok := netipx.FromStdIPNet(r.network)
+		if !ok {
+			return netipx.IPRange{}, fmt.Errorf("invalid network %s", r.network)
+		}
+		return netipx.RangeOfPrefix(prefix), nil
+	}
+	start, _ := netipx.FromStdIP(r.start)
+	end, _ := netipx.FromStdIP(r.end)
+	ipRange := netipx.IPRangeFrom(start, end)
+	if !ipRange.IsValid() {
+		return netipx.IPRange{}, fmt.Errorf("invalid range %s-%s", r.start, r.end)
+	}
+	return ipRange, nil
+}
+
+// networks returns the networks to insert for the record, whose range is
+// ipRange.
+func (r *patchRecord) networks(ipRange netipx.IPRange) []*net.IPNet {
+	if r.network != nil {
+		return []*net.IPNet{r.network}
+	}
+	var networks []*net.IPNet
+	for _, prefix := range ipRange.Prefixes() {
+		networks = append(networks, netipx.PrefixIPNet(prefix))
+	}
+	return networks
+}
+
+// splits returns the number of networks with data in the tree that
+// inserting the range splits, i.e., that overlap the range without being
+// within it. As the networks in the tree do not overlap, only the networks
+// containing the first and the last address of the range may do so. Each
+// is counted once, however many of the range's networks fall into it.
+func (p *patcher) splits(ipRange netipx.IPRange) int {
+	splits := 0
+	var previous netip.Prefix
+	for _, ip := range []netip.Addr{ipRange.From(), ipRange.To()} {
+		network, value := p.tree.Get(net.IP(ip.AsSlice()))
+		if value == nil {
+			continue
+		}
+		prefix, ok := netipx.FromStdIPNet(network)
+		if !ok || prefix == previous {
+			continue
+		}
+		previous = prefix
+		existing := netipx.RangeOfPrefix(prefix)
+		if existing.From().Less(ipRange.From()) || ipRange.To().Less(existing.To()) {
+			splits++
+		}
+	}
+	return splits
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/export"
+)
+
+var exportCommand = &command{
+	name:  "export",
+	usage: "-format nginx-geo|haproxy-map|ipset [-field path] [-values list] [flags] db.mmdb",
+	summary: "Write the networks of a database, or a single field of their data, as an nginx geo block,\n" +
+		"an HAProxy map file, or an ipset restore script, collapsing adjacent networks with equal values.",
+}
+
+func init() {
+	exportCommand.run = runExport
+}
+
+// exporters are the export functions by format name.
+var exporters = map[string]func(io.Writer, *mmdbwriter.Tree, export.Options) (int, error){
+	"nginx-geo":   export.WriteNginxGeo,
+	"haproxy-map": export.WriteHAProxyMap,
+	"ipset":       export.WriteIPSet,
+}
+
+func runExport(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet(exportCommand, stderr)
+	format := fs.String("format", "", "output format: nginx-geo, haproxy-map, or ipset")
+	field := fs.String("field", "", "dotted path of the value to export, e.g., country.iso_code (default the whole value)")
+	var values listFlag
+	fs.Var(&values, "values", "only export the networks with these values (repeatable or comma-separated)")
+	cidr := fs.String("cidr", "", "only export the networks within this network")
+	variable := fs.String("variable", "$geo", "name of the nginx variable")
+	defaultValue := fs.String("default", "", "value of the nginx variable for addresses not in any network")
+	setName := fs.String("set-name", "geo", "prefix of the names of the ipset sets")
+	out := fs.String("o", "", "path of the file to write (default stdout)")
+	var lf loadFlags
+	lf.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	write, ok := exporters[*format]
+	switch {
+	case *format == "":
+		return usageError("-format is required")
+	case !ok:
+		return usageError(fmt.Sprintf("unknown format %q", *format))
+	case fs.NArg() != 1:
+		return usageError("exactly one database is required")
+	}
+
+	opts := export.Options{
+		Field:    *field,
+		Values:   values,
+		Variable: *variable,
+		Default:  *defaultValue,
+		SetName:  *setName,
+	}
+	if *cidr != "" {
+		_, network, err := net.ParseCIDR(*cidr)
+		if err != nil {
+			return usageError(fmt.Sprintf("invalid -cidr: %v", err))
+		}
+		opts.Network = network
+	}
+
+	tree, err := lf.loadTree(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *out == "" {
+		_, err := write(stdout, tree, opts)
+		return err
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	n, err := write(f, tree, opts)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "wrote %d networks to %s\n", n, *out)
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/jsonl"
+)
+
+var importCommand = &command{
+	name:    "import",
+	usage:   "-o out.mmdb [-typed | -schema schema.json] [flags] file.jsonl...",
+	summary: "Build a database from JSON Lines files. Use - to read standard input.",
+}
+
+func init() {
+	importCommand.run = runImport
+}
+
+func runImport(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet(importCommand, stderr)
+	var tf treeFlags
+	tf.register(fs, "replace")
+	typed := fs.Bool("typed", false, "read typed JSON as written by dump -typed")
+	schemaPath := fs.String("schema", "", "JSON file setting the types of values in plain JSON")
+	out := fs.String("o", "", "path of the database to write")
+	checksum := fs.Bool("checksum", false, "also write a sha256sum file next to the database")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	switch {
+	case *out == "":
+		return usageError("-o is required")
+	case *typed && *schemaPath != "":
+		return usageError("-schema may not be used with -typed")
+	case fs.NArg() == 0:
+		return usageError("at least one JSON Lines file is required")
+	}
+
+	var schema *jsonl.Schema
+	if *schemaPath != "" {
+		var err error
+		schema, err = jsonl.LoadSchema(*schemaPath)
+		if err != nil {
+			return err
+		}
+	}
+
+	opts, err := tf.options()
+	if err != nil {
+		return err
+	}
+	tree, err := mmdbwriter.New(opts)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range fs.Args() {
+		n, err := importFile(tree, path, *typed, schema, opts.Inserter)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Fprintf(stdout, "%s: inserted %d records\n", path, n)
+	}
+
+	return writeTree(tree, *out, *checksum, stdout)
+}
+
+// stdin is read for the path "-". It is a variable so that tests may
+// replace it.
+var stdin io.Reader = os.Stdin
+
+func importFile(
+	tree *mmdbwriter.Tree,
+	path string,
+	typed bool,
+	schema *jsonl.Schema,
+	f inserter.FuncGenerator,
+) (int, error) {
+	in := stdin
+	if path != "-" {
+		fh, err := os.Open(path) //nolint:gosec // reading the file is the point
+		if err != nil {
+			return 0, err
+		}
+		defer fh.Close()
+		in = fh
+	}
+
+	r := jsonl.NewReader(in)
+	r.Typed = typed
+	r.Schema = schema
+	return jsonl.Insert(tree, r, f)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+var inspectCommand = &command{
+	name:    "inspect",
+	usage:   "db.mmdb",
+	summary: "Print the metadata and statistics of a database and flag anything suspicious.",
+}
+
+func init() {
+	inspectCommand.run = runInspect
+}
+
+// metadataStartMarker precedes the metadata at the end of a database.
+var metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+// maxMetadataSize is how much of the end of a database readers search for
+// the metadata start marker.
+const maxMetadataSize = 128 * 1024
+
+// dataSectionSeparatorSize is the size of the zeros between the search tree
+// and the data section.
+const dataSectionSeparatorSize = 16
+
+func runInspect(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet(inspectCommand, stderr)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usageError("exactly one database is required")
+	}
+	path := fs.Arg(0)
+
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	stats, err := inspectDatabase(reader)
+	if err != nil {
+		return err
+	}
+	if err := stats.readLayout(path, reader.Metadata); err != nil {
+		return err
+	}
+
+	md := reader.Metadata
+	w := &tabWriter{w: stdout}
+	w.section("Metadata")
+	w.row("database type", md.DatabaseType)
+	for _, lang := range sortedKeys(md.Description) {
+		w.row("description ("+lang+")", md.Description[lang])
+	}
+	w.row("languages", strings.Join(md.Languages, ", "))
+	w.row("IP version", md.IPVersion)
+	w.row("record size", fmt.Sprintf("%d bits", md.RecordSize))
+	w.row("node count", md.NodeCount)
+	w.row("build time", fmt.Sprintf(
+		"%s (%d)",
+		time.Unix(int64(md.BuildEpoch), 0).UTC().Format(time.RFC3339), //nolint:gosec // epochs fit
+		md.BuildEpoch,
+	))
+	w.row("format version", fmt.Sprintf("%d.%d", md.BinaryFormatMajorVersion, md.BinaryFormatMinorVersion))
+
+	w.section("Statistics")
+	w.row("networks", stats.networks)
+	w.row("unique records", stats.uniqueRecords)
+	w.row("search tree size", formatSize(stats.searchTreeSize))
+	w.row("data section size", formatSize(stats.dataSectionSize))
+	w.row("metadata size", formatSize(stats.metadataSize))
+	w.row("file size", formatSize(stats.fileSize))
+
+	warnings := stats.warnings(md)
+	if len(warnings) > 0 {
+		w.section("Warnings")
+		for _, warning := range warnings {
+			w.printf("  - %s\n", warning)
+		}
+	}
+	return w.err
+}
+
+// databaseStats describes the layout and contents of a database.
+type databaseStats struct {
+	searchTreeSize  int64
+	dataSectionSize int64
+	metadataSize    int64
+	fileSize        int64
+
+	// networks is the number of networks with data, not counting the
+	// networks aliased to the IPv4 subtree.
+	networks int
+	// uniqueRecords is the number of distinct records referred to by the
+	// search tree.
+	uniqueRecords int
+	// maxRecordValue is the largest value stored in a record.
+	maxRecordValue uint64
+	// nameLanguages counts the records using each language in a "names"
+	// map.
+	nameLanguages map[string]int
+}
+
+// inspectDatabase counts the networks and records of the database.
+func inspectDatabase(reader *maxminddb.Reader) (*databaseStats, error) {
+	stats := &databaseStats{
+		// Empty records hold the node count, and pointers to nodes are
+		// smaller.
+		maxRecordValue: uint64(reader.Metadata.NodeCount),
+		nameLanguages:  map[string]int{},
+	}
+
+	var opts []maxminddb.NetworksOption
+	if reader.Metadata.IPVersion == 6 {
+		opts = append(opts, maxminddb.SkipAliasedNetworks)
+	}
+	// Records are identified by their offset in the data section, as
+	// networks with the same data refer to the same record.
+	offsets := map[uintptr]struct{}{}
+	networks := reader.Networks(opts...)
+	for networks.Next() {
+		var record any
+		network, err := networks.Network(&record)
+		if err != nil {
+			return nil, err
+		}
+		stats.networks++
+
+		offset, err := reader.LookupOffset(network.IP)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := offsets[offset]; ok {
+			continue
+		}
+		offsets[offset] = struct{}{}
+		// The records of the search tree point past the nodes and the
+		// separator into the data section.
+		value := uint64(reader.Metadata.NodeCount) + dataSectionSeparatorSize + uint64(offset)
+		if value > stats.maxRecordValue {
+			stats.maxRecordValue = value
+		}
+
+		langs := map[string]struct{}{}
+		collectNameLanguages(record, langs)
+		for lang := range langs {
+			stats.nameLanguages[lang]++
+		}
+	}
+	if err := networks.Err(); err != nil {
+		return nil, err
+	}
+	stats.uniqueRecords = len(offsets)
+	return stats, nil
+}
+
+// readLayout sets the sizes of the sections of the database at path. The
+// size of the search tree follows from the metadata, and the metadata
+// section starts at the last metadata start marker.
+func (s *databaseStats) readLayout(path string, md maxminddb.Metadata) error {
+	f, err := os.Open(path) //nolint:gosec // reading the database is the point
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	start := info.Size() - maxMetadataSize
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, info.Size()-start)
+	if _, err := f.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("reading metadata: %w", err)
+	}
+	markerStart := bytes.LastIndex(buf, metadataStartMarker)
+	if markerStart < 0 {
+		return errors.New("metadata start marker not found")
+	}
+	metadataStart := start + int64(markerStart)
+
+	s.fileSize = info.Size()
+	s.searchTreeSize = int64(md.NodeCount * md.RecordSize / 4)
+	s.metadataSize = info.Size() - metadataStart
+	s.dataSectionSize = metadataStart - s.searchTreeSize - dataSectionSeparatorSize
+	if s.dataSectionSize < 0 {
+		return errors.New("the search tree is larger than the database")
+	}
+	return nil
+}
+
+// collectNameLanguages adds the keys of each "names" map in v to langs.
+func collectNameLanguages(v any, langs map[string]struct{}) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if names, ok := e.(map[string]any); ok && k == "names" {
+				for lang := range names {
+					langs[lang] = struct{}{}
+				}
+				continue
+			}
+			collectNameLanguages(e, langs)
+		}
+	case []any:
+		for _, e := range v {
+			collectNameLanguages(e, langs)
+		}
+	}
+}
+
+// warnings returns the suspicious things about the database.
+func (s *databaseStats) warnings(md maxminddb.Metadata) []string {
+	var warnings []string
+	if md.DatabaseType == "" {
+		warnings = append(warnings, "the database type is empty")
+	}
+	if len(md.Description) == 0 {
+		warnings = append(warnings, "the description is empty")
+	}
+	for _, lang := range sortedKeys(md.Description) {
+		if strings.TrimSpace(md.Description[lang]) == "" {
+			warnings = append(warnings, fmt.Sprintf("the %s description is empty", lang))
+		}
+	}
+	if s.networks == 0 {
+		warnings = append(warnings, "the database contains no data")
+	}
+
+	listed := map[string]bool{}
+	for _, lang := range md.Languages {
+		listed[lang] = true
+		if s.nameLanguages[lang] == 0 {
+			warnings = append(warnings, fmt.Sprintf("language %s is listed in the metadata but no names use it", lang))
+		}
+	}
+	for _, lang := range sortedKeys(s.nameLanguages) {
+		if !listed[lang] {
+			warnings = append(warnings, fmt.Sprintf(
+				"%d records have names in %s, which is not listed in the metadata languages",
+				s.nameLanguages[lang],
+				lang,
+			))
+		}
+	}
+
+	if md.BuildEpoch == 0 {
+		warnings = append(warnings, "the build epoch is zero")
+	} else if time.Unix(int64(md.BuildEpoch), 0).After(time.Now().Add(24 * time.Hour)) { //nolint:gosec // epochs fit
+		warnings = append(warnings, "the build time is in the future")
+	}
+
+	for _, size := range []uint{24, 28} {
+		if size >= md.RecordSize {
+			break
+		}
+		if s.maxRecordValue < 1<<size {
+			warnings = append(warnings, fmt.Sprintf(
+				"a record size of %d bits would suffice, which would save %s",
+				size,
+				formatSize(int64(md.NodeCount*(md.RecordSize-size)/4)),
+			))
+			break
+		}
+	}
+
+	return warnings
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB (%d B)", float64(n)/float64(div), "KMGTPE"[exp], n)
+}
+
+// tabWriter writes aligned sections of labeled rows.
+type tabWriter struct {
+	w        io.Writer
+	sections int
+	err      error
+}
+
+func (w *tabWriter) section(name string) {
+	if w.sections > 0 {
+		w.printf("\n")
+	}
+	w.sections++
+	w.printf("%s\n", name)
+}
+
+func (w *tabWriter) row(label string, value any) {
+	w.printf("  %-22s %v\n", label+":", value)
+}
+
+func (w *tabWriter) printf(format string, args ...any) {
+	if w.err == nil {
+		_, w.err = fmt.Fprintf(w.w, format, args...)
+	}
+}
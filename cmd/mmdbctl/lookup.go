@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+var lookupCommand = &command{
+	name:    "lookup",
+	usage:   "db.mmdb ip...",
+	summary: "Print the network containing each IP address and its data as JSON.",
+}
+
+func init() {
+	lookupCommand.run = runLookup
+}
+
+func runLookup(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet(lookupCommand, stderr)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return usageError("a database and at least one IP address are required")
+	}
+
+	ips := make([]net.IP, fs.NArg()-1)
+	for i, s := range fs.Args()[1:] {
+		if ips[i] = net.ParseIP(s); ips[i] == nil {
+			return usageError(fmt.Sprintf("invalid IP address %q", s))
+		}
+	}
+
+	reader, err := maxminddb.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	for _, ip := range ips {
+		var record any
+		network, ok, err := reader.LookupNetwork(ip, &record)
+		if err != nil {
+			return fmt.Errorf("looking up %s: %w", ip, err)
+		}
+		if !ok {
+			fmt.Fprintf(stdout, "%s: %s has no data\n", ip, network)
+			continue
+		}
+		b, err := json.MarshalIndent(record, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encoding the data for %s: %w", ip, err)
+		}
+		fmt.Fprintf(stdout, "%s: %s\n%s\n", ip, network, b)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// mmdbctl builds and works with MaxMind DB files without writing any Go.
+//
+// Usage:
+//
+//	mmdbctl <command> [flags] [arguments]
+//
+// Run "mmdbctl help <command>" for the flags accepted by a command.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// command is a subcommand of mmdbctl.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	// run runs the command with the flags and arguments following its name.
+	run func(args []string, stdout, stderr io.Writer) error
+}
+
+var commands = []*command{
+	buildCommand,
+	dumpCommand,
+	importCommand,
+	rirCommand,
+	enrichCommand,
+	lookupCommand,
+	inspectCommand,
+	diffCommand,
+	serveCommand,
+	exportCommand,
+	csvCommand,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+
+	name := args[0]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		if len(args) > 1 {
+			if cmd := findCommand(args[1]); cmd != nil {
+				return runCommand(cmd, []string{"-h"}, stdout, stderr)
+			}
+		}
+		usage(stdout)
+		return 0
+	}
+
+	cmd := findCommand(name)
+	if cmd == nil {
+		fmt.Fprintf(stderr, "mmdbctl: unknown command %q\n\n", name)
+		usage(stderr)
+		return 2
+	}
+	return runCommand(cmd, args[1:], stdout, stderr)
+}
+
+func runCommand(cmd *command, args []string, stdout, stderr io.Writer) int {
+	err := cmd.run(args, stdout, stderr)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case errors.Is(err, errInvalidFlags):
+		return 2
+	case errors.As(err, new(usageError)):
+		fmt.Fprintf(stderr, "mmdbctl %s: %v\nusage: mmdbctl %s %s\n", cmd.name, err, cmd.name, cmd.usage)
+		return 2
+	default:
+		fmt.Fprintf(stderr, "mmdbctl %s: %v\n", cmd.name, err)
+		return 1
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprint(w, "usage: mmdbctl <command> [flags] [arguments]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprint(w, "\nRun \"mmdbctl help <command>\" for more information about a command.\n")
+}
+
+// usageError is returned by a command when it is invoked incorrectly.
+type usageError string
+
+func (e usageError) Error() string {
+	return string(e)
+}
+
+// newFlagSet returns a FlagSet for the command that writes its usage to
+// stderr and returns errors from Parse rather than exiting.
+func newFlagSet(cmd *command, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: mmdbctl %s %s\n\n%s\n\nflags:\n", cmd.name, cmd.usage, cmd.summary)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// errInvalidFlags is returned by parseFlags when the flags of a command
+// could not be parsed.
+var errInvalidFlags = errors.New("invalid flags")
+
+// parseFlags parses the flags of a command. It returns flag.ErrHelp if
+// -h or -help was given and errInvalidFlags if the flags could not be
+// parsed. In both cases, the usage has already been printed along with
+// any error, so the error is not reported again.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return flag.ErrHelp
+		}
+		return errInvalidFlags
+	}
+	return nil
+}
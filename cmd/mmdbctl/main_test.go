@@ -0,0 +1,661 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mmdbctl runs the command and returns its exit code and output.
+func mmdbctl(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func writeFile(t *testing.T, dir, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+	return path
+}
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	spec := writeFile(t, dir, "spec.json", `{
+  "network": "network",
+  "fields": [
+    {"column": "asn", "path": "autonomous_system_number", "type": "uint32"},
+    {"column": "org", "path": "autonomous_system_organization"}
+  ]
+}`)
+	ipv4 := writeFile(t, dir, "ipv4.csv", "network,asn,org\n1.0.0.0/24,13335,Cloudflare\n")
+	ipv6 := writeFile(t, dir, "ipv6.csv", "network,asn,org\n2001:db8::/32,64496,\n")
+	out := filepath.Join(dir, "out.mmdb")
+
+	code, stdout, stderr := mmdbctl(t,
+		"build",
+		"-spec", spec,
+		"-o", out,
+		"-database-type", "My-ASN-DB",
+		"-record-size", "24",
+		"-description", "en=My ASN database",
+		"-include-reserved-networks",
+		"-checksum",
+		ipv4, ipv6,
+	)
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "ipv4.csv: inserted 1 rows")
+
+	reader, err := maxminddb.Open(out)
+	require.NoError(t, err)
+	defer reader.Close()
+	require.NoError(t, reader.Verify())
+
+	assert.Equal(t, "My-ASN-DB", reader.Metadata.DatabaseType)
+	assert.Equal(t, uint(24), reader.Metadata.RecordSize)
+	assert.Equal(t, map[string]string{"en": "My ASN database"}, reader.Metadata.Description)
+
+	var record map[string]any
+	require.NoError(t, reader.Lookup(net.ParseIP("1.0.0.1"), &record))
+	assert.Equal(t, map[string]any{
+		"autonomous_system_number":       uint64(13335),
+		"autonomous_system_organization": "Cloudflare",
+	}, record)
+
+	record = nil
+	require.NoError(t, reader.Lookup(net.ParseIP("2001:db8::1"), &record))
+	assert.Equal(t, map[string]any{"autonomous_system_number": uint64(64496)}, record)
+
+	_, err = os.Stat(out + ".sha256")
+	assert.NoError(t, err)
+}
+
+func TestBuildErrors(t *testing.T) {
+	dir := t.TempDir()
+	spec := writeFile(t, dir, "spec.json", `{"network": "network", "fields": [{"column": "asn", "type": "uint32"}]}`)
+	csv := writeFile(t, dir, "bad.csv", "network,asn\n1.0.0.0/24,x\n")
+	out := filepath.Join(dir, "out.mmdb")
+
+	code, _, stderr := mmdbctl(t, "build", "-o", out, csv)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "-spec is required")
+
+	code, _, stderr = mmdbctl(t, "build", "-spec", spec, "-o", out, "-inserter", "nope", csv)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown inserter "nope"`)
+
+	code, _, stderr = mmdbctl(t, "build", "-spec", spec, "-o", out, "-include-reserved-networks", csv)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, `bad.csv: line 2: column asn: invalid uint32 "x"`)
+
+	_, err := os.Stat(out)
+	assert.True(t, os.IsNotExist(err), "no database is written")
+
+	code, _, stderr = mmdbctl(t, "frobnicate")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown command "frobnicate"`)
+
+	code, _, stderr = mmdbctl(t, "build", "-bogus")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "flag provided but not defined: -bogus")
+	assert.Contains(t, stderr, "usage: mmdbctl build")
+
+	code, _, stderr = mmdbctl(t, "build", "-record-size", "abc")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `invalid value "abc" for flag -record-size`)
+
+	code, stdout, stderr := mmdbctl(t, "build", "-h")
+	assert.Equal(t, 0, code)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "usage: mmdbctl build")
+}
+
+// buildTestDatabase builds a small database using the build command.
+func buildTestDatabase(t *testing.T, dir string) string {
+	t.Helper()
+	spec := writeFile(t, dir, "spec.json", `{
+  "network": "network",
+  "fields": [
+    {"column": "asn", "path": "autonomous_system_number", "type": "uint32"},
+    {"column": "org", "path": "autonomous_system_organization"}
+  ]
+}`)
+	csv := writeFile(t, dir, "blocks.csv", `network,asn,org
+1.0.0.0/24,13335,Cloudflare
+1.0.4.0/22,38803,Wirefree
+2600:1400::/24,20940,Akamai
+`)
+	out := filepath.Join(dir, "test.mmdb")
+	code, _, stderr := mmdbctl(t,
+		"build", "-spec", spec, "-o", out, "-build-epoch", "1",
+		"-database-type", "Test-ASN", "-description", "en=Test ASN database",
+		csv,
+	)
+	require.Equal(t, 0, code, stderr)
+	return out
+}
+
+func TestDump(t *testing.T) {
+	db := buildTestDatabase(t, t.TempDir())
+
+	code, stdout, stderr := mmdbctl(t, "dump", db)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t,
+		`{"network":"1.0.0.0/24","data":{"autonomous_system_number":13335,"autonomous_system_organization":"Cloudflare"}}
+{"network":"1.0.4.0/22","data":{"autonomous_system_number":38803,"autonomous_system_organization":"Wirefree"}}
+{"network":"2600:1400::/24","data":{"autonomous_system_number":20940,"autonomous_system_organization":"Akamai"}}
+`,
+		stdout,
+	)
+
+	code, stdout, stderr = mmdbctl(t, "dump", "-typed", "-aliased", "-cidr", "::ffff:0:0/96", db)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t,
+		`{"network":"::ffff:1.0.0.0/120","data":{"map":{"autonomous_system_number":{"uint32":13335},`+
+			`"autonomous_system_organization":{"string":"Cloudflare"}}}}
+{"network":"::ffff:1.0.4.0/118","data":{"map":{"autonomous_system_number":{"uint32":38803},`+
+			`"autonomous_system_organization":{"string":"Wirefree"}}}}
+`,
+		stdout,
+	)
+
+	code, _, stderr = mmdbctl(t, "dump", "-cidr", "nope", db)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "invalid -cidr")
+}
+
+func TestDumpWithoutIPv4Aliasing(t *testing.T) {
+	dir := t.TempDir()
+	patch := writeFile(t, dir, "mapped.jsonl", `{"network":"::ffff:1.0.0.0/120","data":{"a":1}}`+"\n")
+	db := filepath.Join(dir, "mapped.mmdb")
+	code, _, stderr := mmdbctl(t, "import", "-disable-ipv4-aliasing", "-o", db, patch)
+	require.Equal(t, 0, code, stderr)
+
+	// The IPv4-mapped network is not an alias, so it may only be loaded
+	// with aliasing disabled.
+	code, _, _ = mmdbctl(t, "dump", db)
+	assert.Equal(t, 1, code)
+
+	code, stdout, stderr := mmdbctl(t, "dump", "-disable-ipv4-aliasing", db)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, `{"network":"::ffff:1.0.0.0/120","data":{"a":1}}`+"\n", stdout)
+
+	code, stdout, stderr = mmdbctl(t, "diff", "-disable-ipv4-aliasing", db, db)
+	require.Equal(t, 0, code, stderr)
+	assert.NotContains(t, stdout, "::ffff:1.0.0.0/120")
+}
+
+func TestImport(t *testing.T) {
+	dir := t.TempDir()
+	db := buildTestDatabase(t, dir)
+
+	dumped := filepath.Join(dir, "dump.jsonl")
+	code, _, stderr := mmdbctl(t, "dump", "-typed", "-o", dumped, db)
+	require.Equal(t, 0, code, stderr)
+
+	imported := filepath.Join(dir, "imported.mmdb")
+	code, stdout, stderr := mmdbctl(t,
+		"import", "-typed", "-o", imported, "-build-epoch", "1",
+		"-database-type", "Test-ASN", "-description", "en=Test ASN database",
+		dumped,
+	)
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "dump.jsonl: inserted 3 records")
+
+	expected, err := os.ReadFile(db)
+	require.NoError(t, err)
+	actual, err := os.ReadFile(imported)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual, "the round trip is lossless")
+
+	schema := writeFile(t, dir, "schema.json", `{"types": {"asn": "uint16"}}`)
+	stdin = strings.NewReader(`{"start":"1.0.0.1","end":"1.0.0.9","data":{"asn":1,"org":"x"}}` + "\n")
+	defer func() { stdin = os.Stdin }()
+	code, _, stderr = mmdbctl(t, "import", "-schema", schema, "-o", imported, "-inserter", "deep-merge", "-")
+	require.Equal(t, 0, code, stderr)
+
+	reader, err := maxminddb.Open(imported)
+	require.NoError(t, err)
+	defer reader.Close()
+	var record any
+	network, ok, err := reader.LookupNetwork(net.ParseIP("1.0.0.8"), &record)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "1.0.0.8/31", network.String())
+	assert.Equal(t, map[string]any{"asn": uint64(1), "org": "x"}, record)
+
+	code, _, stderr = mmdbctl(t, "import", "-typed", "-schema", schema, "-o", imported, "-")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "-schema may not be used with -typed")
+}
+
+func TestRIR(t *testing.T) {
+	dir := t.TempDir()
+	apnic := writeFile(t, dir, "delegated-apnic-extended-latest", `2.3|apnic|20240101|3|19830613|20231231|+1000
+apnic|*|ipv4|*|2|summary
+apnic|*|ipv6|*|1|summary
+apnic|AU|ipv4|1.0.0.0|256|20110811|assigned|A91872ED
+apnic|CN|ipv4|1.0.1.0|768|20110414|allocated|A92E1062
+apnic|JP|ipv6|2001:dc0::|32|20020801|allocated|A91CBC82
+`)
+	arin := writeFile(t, dir, "delegated-arin-extended-latest", `2.3|arin|1704085200|2|19700101|20231231|-0500
+arin|US|ipv4|3.0.0.0|16777216|20170120|allocated|5a8d4a4e
+arin|ZZ|ipv4|4.0.0.0|256||reserved|
+`)
+
+	out := filepath.Join(dir, "rir.mmdb")
+	code, stdout, stderr := mmdbctl(t, "rir", "-o", out, "-database-type", "RIR-Country", apnic, arin)
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "delegated-apnic-extended-latest: inserted 3 blocks")
+	assert.Contains(t, stdout, "delegated-arin-extended-latest: inserted 1 blocks")
+
+	reader, err := maxminddb.Open(out)
+	require.NoError(t, err)
+	var record any
+	network, ok, err := reader.LookupNetwork(net.ParseIP("1.0.3.1"), &record)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "1.0.2.0/23", network.String())
+	assert.Equal(t, map[string]any{
+		"country": map[string]any{"iso_code": "CN"},
+		"allocation": map[string]any{
+			"registry":  "apnic",
+			"status":    "allocated",
+			"date":      "2011-04-14",
+			"opaque_id": "A92E1062",
+		},
+	}, record)
+	_, ok, err = reader.LookupNetwork(net.ParseIP("4.0.0.1"), &record)
+	require.NoError(t, err)
+	assert.False(t, ok, "reserved blocks are skipped by default")
+	require.NoError(t, reader.Close())
+
+	code, _, stderr = mmdbctl(t, "rir", "-o", out, "-status", "reserved", arin)
+	require.Equal(t, 0, code, stderr)
+	reader, err = maxminddb.Open(out)
+	require.NoError(t, err)
+	defer reader.Close()
+	_, ok, err = reader.LookupNetwork(net.ParseIP("4.0.0.1"), &record)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	bad := writeFile(t, dir, "bad", "arin|US|ipv4|3.0.0.0|0|20170120|allocated\n")
+	code, _, stderr = mmdbctl(t, "rir", "-o", out, bad)
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, `line 1: invalid number of addresses "0"`)
+}
+
+func TestEnrich(t *testing.T) {
+	dir := t.TempDir()
+	db := buildTestDatabase(t, dir)
+
+	spec := writeFile(t, dir, "patch-spec.json", `{
+  "start": "first",
+  "end": "last",
+  "fields": [{"column": "note", "path": "notes.text"}]
+}`)
+	csvPatch := writeFile(t, dir, "patch.csv", "first,last,note\n1.0.0.0,1.0.0.127,hello\n")
+	jsonPatch := writeFile(t, dir, "patch.jsonl", `{"network":"1.0.4.0/22","data":{"notes":{"id":7}}}
+{"network":"2600:1400::/24","data":{"autonomous_system_organization":"Akamai Technologies"}}
+`)
+	out := filepath.Join(dir, "enriched.mmdb")
+
+	code, stdout, stderr := mmdbctl(t, "enrich", "-spec", spec, "-o", out, db, csvPatch, jsonPatch)
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "patch.csv: applied 1 records, touched 1 networks, split 1 networks")
+	assert.Contains(t, stdout, "patch.jsonl: applied 2 records, touched 2 networks, split 0 networks")
+	assert.Contains(t, stdout, "total: applied 3 records, touched 3 networks, split 1 networks")
+
+	reader, err := maxminddb.Open(out)
+	require.NoError(t, err)
+	defer reader.Close()
+	require.NoError(t, reader.Verify())
+	assert.Equal(t, uint(28), reader.Metadata.RecordSize)
+
+	var record any
+	network, _, err := reader.LookupNetwork(net.ParseIP("1.0.0.1"), &record)
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0.0/25", network.String())
+	assert.Equal(t, map[string]any{
+		"autonomous_system_number":       uint64(13335),
+		"autonomous_system_organization": "Cloudflare",
+		"notes":                          map[string]any{"text": "hello"},
+	}, record)
+
+	record = nil
+	require.NoError(t, reader.Lookup(net.ParseIP("1.0.5.1"), &record))
+	assert.Equal(t, map[string]any{"id": uint64(7)}, record.(map[string]any)["notes"])
+
+	record = nil
+	require.NoError(t, reader.Lookup(net.ParseIP("2600:1400::1"), &record))
+	assert.Equal(t, "Akamai Technologies", record.(map[string]any)["autonomous_system_organization"])
+
+	code, _, stderr = mmdbctl(t, "enrich", "-o", out, "-inserter", "remove", db, jsonPatch)
+	require.Equal(t, 0, code, stderr)
+	code, stdout, stderr = mmdbctl(t, "dump", out)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, 1, strings.Count(stdout, "\n"), "only 1.0.0.0/24 is left")
+
+	code, _, stderr = mmdbctl(t, "enrich", "-o", out, db, csvPatch)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "-spec is required for CSV patches")
+
+	// The first range is inserted as several networks within 1.0.0.0/24 and
+	// the second covers the gap after it and ends within 1.0.4.0/22, but
+	// each of the two networks is split only once.
+	spanningPatch := writeFile(t, dir, "spanning.jsonl", `{"start":"1.0.0.0","end":"1.0.0.200","data":{"a":1}}
+{"start":"1.0.0.201","end":"1.0.4.200","data":{"b":2}}
+{"network":"1.0.0.0/16","data":{"c":3}}
+`)
+	code, stdout, stderr = mmdbctl(t, "enrich", "-o", out, db, spanningPatch)
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "spanning.jsonl: applied 3 records,")
+	assert.Contains(t, stdout, "split 2 networks")
+}
+
+func TestLookup(t *testing.T) {
+	db := buildTestDatabase(t, t.TempDir())
+
+	code, stdout, stderr := mmdbctl(t, "lookup", db, "1.0.0.1", "2.2.2.2")
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, `1.0.0.1: 1.0.0.0/24
+{
+  "autonomous_system_number": 13335,
+  "autonomous_system_organization": "Cloudflare"
+}
+2.2.2.2: 2.0.0.0/7 has no data
+`, stdout)
+
+	code, _, stderr = mmdbctl(t, "lookup", db, "nope")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `invalid IP address "nope"`)
+}
+
+func TestInspect(t *testing.T) {
+	dir := t.TempDir()
+	db := buildTestDatabase(t, dir)
+
+	code, stdout, stderr := mmdbctl(t, "inspect", db)
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "  database type:         Test-ASN\n")
+	assert.Contains(t, stdout, "  description (en):      Test ASN database\n")
+	assert.Contains(t, stdout, "  node count:            385\n")
+	assert.Contains(t, stdout, "  networks:              3\n")
+	assert.Contains(t, stdout, "  unique records:        3\n")
+	assert.Contains(t, stdout, "  search tree size:      2.6 KiB (2695 B)\n")
+	assert.NotContains(t, stdout, "is empty")
+
+	spec := writeFile(t, dir, "names-spec.json", `{
+  "network": "network",
+  "fields": [{"column": "name", "path": "city.names.fr"}]
+}`)
+	csv := writeFile(t, dir, "names.csv", "network,name\n1.0.0.0/24,Paris\n")
+	out := filepath.Join(dir, "names.mmdb")
+	code, _, stderr = mmdbctl(t, "build", "-spec", spec, "-o", out, "-record-size", "32", "-language", "de", csv)
+	require.Equal(t, 0, code, stderr)
+
+	code, stdout, stderr = mmdbctl(t, "inspect", out)
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, `Warnings
+  - the database type is empty
+  - the description is empty
+  - language de is listed in the metadata but no names use it
+  - 1 records have names in fr, which is not listed in the metadata languages
+  - a record size of 24 bits would suffice, which would save 734 B
+`)
+
+	// Reserved networks are reported like any other network.
+	reserved := writeFile(t, dir, "reserved.jsonl", `{"network":"10.0.0.0/8","data":{"a":1}}`+"\n")
+	out = filepath.Join(dir, "reserved.mmdb")
+	code, _, stderr = mmdbctl(t, "import", "-include-reserved-networks", "-o", out, reserved)
+	require.Equal(t, 0, code, stderr)
+
+	code, stdout, stderr = mmdbctl(t, "inspect", out)
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "  networks:              1\n")
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	old := buildTestDatabase(t, dir)
+
+	patch := writeFile(t, dir, "patch.jsonl",
+		`{"network":"1.0.0.0/25","data":{"autonomous_system_organization":"Cloudflare, Inc."}}
+{"network":"1.0.8.0/24","data":{"autonomous_system_number":1}}
+{"network":"2600:1400::/24","data":{"country":{"iso_code":"US"}}}
+`)
+	removal := writeFile(t, dir, "removal.jsonl", `{"network":"1.0.4.0/22","data":{}}`+"\n")
+	patched := filepath.Join(dir, "patched.mmdb")
+	code, _, stderr := mmdbctl(t, "enrich", "-o", patched, old, patch)
+	require.Equal(t, 0, code, stderr)
+	updated := filepath.Join(dir, "updated.mmdb")
+	code, _, stderr = mmdbctl(t, "enrich", "-o", updated, "-inserter", "remove", patched, removal)
+	require.Equal(t, 0, code, stderr)
+
+	code, stdout, stderr := mmdbctl(t, "diff", old, updated)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, `1.0.0.0/25
+  ~ autonomous_system_organization: "Cloudflare" -> "Cloudflare, Inc."
+1.0.4.0/22
+  - autonomous_system_number: 38803
+  - autonomous_system_organization: "Wirefree"
+1.0.8.0/24
+  + autonomous_system_number: 1
+2600:1400::/24
+  + country.iso_code: "US"
+
+1 network added, 1 network removed, 2 networks changed
+  autonomous_system_number: 2 networks (1 added, 1 removed)
+  autonomous_system_organization: 2 networks (1 changed, 1 removed)
+  country: 1 network
+    country.iso_code: 1 added
+`, stdout)
+
+	code, stdout, stderr = mmdbctl(t, "diff", "-format", "jsonl", updated, old)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t,
+		`{"network":"1.0.0.0/25","changes":[{"path":"autonomous_system_organization","kind":"changed",`+
+			`"old":"Cloudflare, Inc.","new":"Cloudflare"}]}
+{"network":"1.0.4.0/22","changes":[{"path":"autonomous_system_number","kind":"added","new":38803},`+
+			`{"path":"autonomous_system_organization","kind":"added","new":"Wirefree"}]}
+{"network":"1.0.8.0/24","changes":[{"path":"autonomous_system_number","kind":"removed","old":1}]}
+{"network":"2600:1400::/24","changes":[{"path":"country.iso_code","kind":"removed","old":"US"}]}
+{"summary":{"added":1,"removed":1,"changed":2,"keys":{"autonomous_system_number":2,`+
+			`"autonomous_system_organization":2,"country":1},"paths":{"autonomous_system_number":`+
+			`{"added":1,"removed":1},"autonomous_system_organization":{"added":1,"changed":1},`+
+			`"country.iso_code":{"removed":1}}}}
+`,
+		stdout,
+	)
+
+	code, stdout, stderr = mmdbctl(t, "diff", "-summary", old, old)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, "0 networks added, 0 networks removed, 0 networks changed\n", stdout)
+
+	code, _, stderr = mmdbctl(t, "diff", "-format", "xml", old, updated)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown format "xml"`)
+}
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	db := buildTestDatabase(t, dir)
+
+	s, err := newLookupServer(func() (*mmdbwriter.Tree, error) { return (&loadFlags{}).loadTree(db) }, []string{db})
+	require.NoError(t, err)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path) //nolint:noctx // test
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{
+			"/lookup/1.0.0.1",
+			http.StatusOK,
+			`{"ip":"1.0.0.1","network":"1.0.0.0/24","data":{"autonomous_system_number":13335,` +
+				`"autonomous_system_organization":"Cloudflare"}}`,
+		},
+		{
+			"/lookup/2600:1400::1",
+			http.StatusOK,
+			`{"ip":"2600:1400::1","network":"2600:1400::/24","data":{"autonomous_system_number":20940,` +
+				`"autonomous_system_organization":"Akamai"}}`,
+		},
+		{
+			"/lookup/2.2.2.2",
+			http.StatusNotFound,
+			`{"code":"IP_ADDRESS_NOT_FOUND","error":"2.2.2.2 has no data"}`,
+		},
+		{
+			"/lookup/nope",
+			http.StatusBadRequest,
+			`{"code":"IP_ADDRESS_INVALID","error":"\"nope\" is not a valid IP address"}`,
+		},
+		{
+			"/networks?cidr=1.0.0.0/16",
+			http.StatusOK,
+			`{"networks":[{"network":"1.0.0.0/24","data":{"autonomous_system_number":13335,` +
+				`"autonomous_system_organization":"Cloudflare"}},{"network":"1.0.4.0/22","data":` +
+				`{"autonomous_system_number":38803,"autonomous_system_organization":"Wirefree"}}]}`,
+		},
+		{
+			"/networks?cidr=2001:db8::/32",
+			http.StatusOK,
+			`{"networks":[]}`,
+		},
+		{
+			"/networks",
+			http.StatusBadRequest,
+			`{"code":"CIDR_REQUIRED","error":"the cidr parameter is required"}`,
+		},
+		{
+			"/elsewhere",
+			http.StatusNotFound,
+			`{"code":"NOT_FOUND","error":"/elsewhere was not found"}`,
+		},
+	}
+	for _, test := range tests {
+		status, body := get(test.path)
+		assert.Equal(t, test.status, status, test.path)
+		assert.Equal(t, test.body+"\n", body, test.path)
+	}
+
+	reloaded, err := s.reload()
+	require.NoError(t, err)
+	assert.False(t, reloaded, "the database did not change")
+
+	patch := writeFile(t, dir, "patch.jsonl", `{"network":"1.0.0.0/24","data":{"autonomous_system_number":1}}`+"\n")
+	updated := filepath.Join(dir, "updated.mmdb")
+	code, _, stderr := mmdbctl(t, "enrich", "-o", updated, "-inserter", "replace", db, patch)
+	require.Equal(t, 0, code, stderr)
+	require.NoError(t, os.Rename(updated, db))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(db, later, later))
+
+	reloaded, err = s.reload()
+	require.NoError(t, err)
+	assert.True(t, reloaded)
+	status, body := get("/lookup/1.0.0.1")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"ip":"1.0.0.1","network":"1.0.0.0/24","data":{"autonomous_system_number":1}}`+"\n", body)
+
+	require.NoError(t, os.WriteFile(db, []byte("not a database"), 0o600))
+	_, err = s.reload()
+	require.Error(t, err)
+	status, _ = get("/lookup/1.0.0.1")
+	assert.Equal(t, http.StatusOK, status, "the last good database is kept")
+}
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	db := buildTestDatabase(t, dir)
+
+	code, stdout, stderr := mmdbctl(t,
+		"export", "-format", "nginx-geo", "-field", "autonomous_system_number", "-variable", "asn", db,
+	)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, `geo $asn {
+    default "";
+    1.0.0.0/24 13335;
+    1.0.4.0/22 38803;
+    2600:1400::/24 20940;
+}
+`, stdout)
+
+	out := filepath.Join(dir, "blocked.ipset")
+	code, stdout, stderr = mmdbctl(t,
+		"export", "-format", "ipset", "-field", "autonomous_system_organization",
+		"-values", "Akamai,Wirefree", "-set-name", "as", "-o", out, db,
+	)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, "wrote 2 networks to "+out+"\n", stdout)
+	b, err := os.ReadFile(out)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "add as-Akamai-v6-tmp 2600:1400::/24\n")
+	assert.Contains(t, string(b), "add as-Wirefree-v4-tmp 1.0.4.0/22\n")
+
+	code, stdout, stderr = mmdbctl(t,
+		"export", "-format", "haproxy-map", "-field", "autonomous_system_organization", "-cidr", "1.0.0.0/8", db,
+	)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, "1.0.0.0/24 Cloudflare\n1.0.4.0/22 Wirefree\n", stdout)
+
+	code, _, stderr = mmdbctl(t, "export", "-format", "xml", db)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown format "xml"`)
+}
+
+func TestCSV(t *testing.T) {
+	dir := t.TempDir()
+	db := buildTestDatabase(t, dir)
+
+	code, stdout, stderr := mmdbctl(t, "csv", "-layout", "flat", db)
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, `network,autonomous_system_number,autonomous_system_organization
+1.0.0.0/24,13335,Cloudflare
+1.0.4.0/22,38803,Wirefree
+2600:1400::/24,20940,Akamai
+`, stdout)
+
+	out := filepath.Join(dir, "csv")
+	code, stdout, stderr = mmdbctl(t, "csv", "-o", out, "-locale", "en", db)
+	require.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "wrote 3 networks\n")
+	for _, name := range []string{"test-Blocks-IPv4.csv", "test-Blocks-IPv6.csv", "test-Locations-en.csv"} {
+		assert.Contains(t, stdout, "wrote "+filepath.Join(out, name)+"\n")
+	}
+	b, err := os.ReadFile(filepath.Join(out, "test-Blocks-IPv4.csv"))
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "\n1.0.4.0/22,,,,0,0,,,,,0\n")
+
+	code, _, stderr = mmdbctl(t, "csv", db)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "-o is required for the geolite2 layout")
+}
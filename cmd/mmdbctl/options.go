@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// loadFlags holds the flags that configure how a database is loaded by
+// the commands that only read it.
+type loadFlags struct {
+	disableIPv4Aliasing bool
+}
+
+// register adds the flags to fs.
+func (f *loadFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.disableIPv4Aliasing, "disable-ipv4-aliasing", false,
+		"load a database built with -disable-ipv4-aliasing, which may have data in ::ffff:0:0/96 and 2002::/16")
+}
+
+// loadTree loads the database at path with the settings from its
+// metadata.
+func (f *loadFlags) loadTree(path string) (*mmdbwriter.Tree, error) {
+	return mmdbwriter.Load(path, mmdbwriter.Options{
+		// The database may contain anything, including reserved networks.
+		IncludeReservedNetworks: true,
+		DisableIPv4Aliasing:     f.disableIPv4Aliasing,
+	})
+}
+
+// treeFlags holds the flags that configure the mmdbwriter.Options of a
+// tree.
+type treeFlags struct {
+	recordSize              int
+	ipVersion               int
+	databaseType            string
+	description             keyValueFlag
+	languages               listFlag
+	extraMetadata           keyValueFlag
+	includeReservedNetworks bool
+	disableIPv4Aliasing     bool
+	disableMetadataPointers bool
+	buildEpoch              int64
+	reproducible            bool
+	keyOrder                listFlag
+	optimize                bool
+	concurrency             int
+	inserter                string
+}
+
+// register adds the flags to fs. Flags that are not set leave the
+// corresponding option unset so that Load uses the value from the loaded
+// database.
+func (f *treeFlags) register(fs *flag.FlagSet, defaultInserter string) {
+	fs.IntVar(&f.recordSize, "record-size", 0, "record size in bits: 24, 28, or 32 (default 28)")
+	fs.IntVar(&f.ipVersion, "ip-version", 0, "IP version of the database: 4 or 6 (default 6)")
+	fs.StringVar(&f.databaseType, "database-type", "", "database type stored in the metadata")
+	fs.Var(&f.description, "description", "description as `lang=text` (repeatable)")
+	fs.Var(&f.languages, "language", "locale code of a language in the database (repeatable or comma-separated)")
+	fs.Var(&f.extraMetadata, "extra-metadata", "additional metadata string as `key=value` (repeatable)")
+	fs.BoolVar(&f.includeReservedNetworks, "include-reserved-networks", false, "allow inserting reserved networks")
+	fs.BoolVar(&f.disableIPv4Aliasing, "disable-ipv4-aliasing", false, "do not alias IPv4 in IPv6 to ::/96")
+	fs.BoolVar(&f.disableMetadataPointers, "disable-metadata-pointers", false,
+		"do not use pointers in the metadata, for older readers")
+	fs.Int64Var(&f.buildEpoch, "build-epoch", 0, "build time in seconds since the Unix epoch (default now)")
+	fs.BoolVar(&f.reproducible, "reproducible", false,
+		"require -build-epoch so that the output does not depend on the time of the build")
+	fs.Var(&f.keyOrder, "key-order", "map keys to write first, in order (repeatable or comma-separated)")
+	fs.BoolVar(&f.optimize, "optimize", false, "lay out the data section to make the database smaller")
+	fs.IntVar(&f.concurrency, "concurrency", runtime.GOMAXPROCS(0), "goroutines used to serialize the data section")
+	fs.StringVar(&f.inserter, "inserter", defaultInserter,
+		"how to combine data for overlapping networks: replace, top-level-merge, deep-merge, or remove")
+}
+
+func (f *treeFlags) options() (mmdbwriter.Options, error) {
+	insert, err := inserterByName(f.inserter)
+	if err != nil {
+		return mmdbwriter.Options{}, err
+	}
+
+	opts := mmdbwriter.Options{
+		BuildEpoch:              f.buildEpoch,
+		DatabaseType:            f.databaseType,
+		DisableIPv4Aliasing:     f.disableIPv4Aliasing,
+		IncludeReservedNetworks: f.includeReservedNetworks,
+		IPVersion:               f.ipVersion,
+		Languages:               f.languages,
+		RecordSize:              f.recordSize,
+		DisableMetadataPointers: f.disableMetadataPointers,
+		Inserter:                insert,
+		Reproducible:            f.reproducible,
+		OptimizeDataSection:     f.optimize,
+		WriteConcurrency:        f.concurrency,
+	}
+	if len(f.description) > 0 {
+		opts.Description = f.description.strings()
+	}
+	if len(f.extraMetadata) > 0 {
+		opts.ExtraMetadata = mmdbtype.Map{}
+		for _, kv := range f.extraMetadata {
+			opts.ExtraMetadata[mmdbtype.String(kv.key)] = mmdbtype.String(kv.value)
+		}
+	}
+	if len(f.keyOrder) > 0 {
+		keys := make([]mmdbtype.String, len(f.keyOrder))
+		for i, k := range f.keyOrder {
+			keys[i] = mmdbtype.String(k)
+		}
+		opts.MapKeyOrder = mmdbtype.PriorityKeyOrder(keys...)
+	}
+	return opts, nil
+}
+
+// inserterByName returns the inserter.FuncGenerator with the provided
+// name.
+func inserterByName(name string) (inserter.FuncGenerator, error) {
+	switch name {
+	case "replace":
+		return inserter.ReplaceWith, nil
+	case "top-level-merge":
+		return inserter.TopLevelMergeWith, nil
+	case "deep-merge":
+		return inserter.DeepMergeWith, nil
+	case "remove":
+		return func(mmdbtype.DataType) inserter.Func { return inserter.Remove }, nil
+	default:
+		return nil, usageError(fmt.Sprintf("unknown inserter %q", name))
+	}
+}
+
+// listFlag is a flag that may be repeated and whose values may be
+// comma-separated.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+type keyValue struct {
+	key   string
+	value string
+}
+
+// keyValueFlag is a flag that may be repeated and whose values are of the
+// form key=value.
+type keyValueFlag []keyValue
+
+func (f *keyValueFlag) String() string {
+	s := make([]string, len(*f))
+	for i, kv := range *f {
+		s[i] = kv.key + "=" + kv.value
+	}
+	return strings.Join(s, " ")
+}
+
+func (f *keyValueFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("expected key=value but got %q", s)
+	}
+	*f = append(*f, keyValue{key: k, value: v})
+	return nil
+}
+
+func (f keyValueFlag) strings() map[string]string {
+	m := make(map[string]string, len(f))
+	for _, kv := range f {
+		m[kv.key] = kv.value
+	}
+	return m
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/rir"
+)
+
+var rirCommand = &command{
+	name:  "rir",
+	usage: "-o out.mmdb [-status list] [flags] delegated-file...",
+	summary: "Build a database from the delegated statistics files of the Regional Internet Registries.\n" +
+		"Use - to read standard input.",
+}
+
+func init() {
+	rirCommand.run = runRIR
+}
+
+func runRIR(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet(rirCommand, stderr)
+	var tf treeFlags
+	tf.register(fs, "replace")
+	var statuses listFlag
+	fs.Var(&statuses, "status", "status of the blocks to insert (repeatable or comma-separated; "+
+		"default allocated,assigned)")
+	out := fs.String("o", "", "path of the database to write")
+	checksum := fs.Bool("checksum", false, "also write a sha256sum file next to the database")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	switch {
+	case *out == "":
+		return usageError("-o is required")
+	case fs.NArg() == 0:
+		return usageError("at least one delegated statistics file is required")
+	}
+
+	opts, err := tf.options()
+	if err != nil {
+		return err
+	}
+	tree, err := mmdbwriter.New(opts)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range fs.Args() {
+		n, err := importRIRFile(tree, path, statuses, opts.Inserter)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Fprintf(stdout, "%s: inserted %d blocks\n", path, n)
+	}
+
+	return writeTree(tree, *out, *checksum, stdout)
+}
+
+func importRIRFile(
+	tree *mmdbwriter.Tree,
+	path string,
+	statuses []string,
+	f inserter.FuncGenerator,
+) (int, error) {
+	in := stdin
+	if path != "-" {
+		fh, err := os.Open(path) //nolint:gosec // reading the file is the point
+		if err != nil {
+			return 0, err
+		}
+		defer fh.Close()
+		in = fh
+	}
+
+	r := rir.NewReader(in)
+	r.Statuses = statuses
+	return rir.Insert(tree, r, f)
+}
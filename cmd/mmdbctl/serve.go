@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/csvimport"
+	"github.com/maxmind/mmdbwriter/jsonl"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+var serveCommand = &command{
+	name:  "serve",
+	usage: "[-addr host:port] [-reload-interval duration] (db.mmdb | -spec spec.json [flags] file.csv...)",
+	summary: "Serve lookups in a database, or in one built from CSV files, as JSON over HTTP:\n\n" +
+		"  GET /lookup/{ip}        the network containing the IP address and its data\n" +
+		"  GET /networks?cidr=...  the networks with data within a network\n\n" +
+		"The files are reloaded when they change.",
+}
+
+func init() {
+	serveCommand.run = runServe
+}
+
+func runServe(args []string, stdout, stderr io.Writer) error {
+	fs := newFlagSet(serveCommand, stderr)
+	var tf treeFlags
+	tf.register(fs, "replace")
+	specPath := fs.String("spec", "", "JSON file mapping the columns of the CSV files to networks and data")
+	addr := fs.String("addr", "localhost:8080", "address to listen on")
+	interval := fs.Duration("reload-interval", time.Second,
+		"how often to check the files for changes; 0 disables reloading")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var load func() (*mmdbwriter.Tree, error)
+	paths := fs.Args()
+	if *specPath == "" {
+		if fs.NArg() != 1 {
+			return usageError("exactly one database is required without -spec")
+		}
+		// -disable-ipv4-aliasing is one of the tree flags.
+		lf := loadFlags{disableIPv4Aliasing: tf.disableIPv4Aliasing}
+		load = func() (*mmdbwriter.Tree, error) { return lf.loadTree(paths[0]) }
+	} else {
+		if fs.NArg() == 0 {
+			return usageError("at least one CSV file is required with -spec")
+		}
+		opts, err := tf.options()
+		if err != nil {
+			return err
+		}
+		load = func() (*mmdbwriter.Tree, error) {
+			return buildTree(*specPath, paths, opts)
+		}
+		paths = append([]string{*specPath}, paths...)
+	}
+
+	s, err := newLookupServer(load, paths)
+	if err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "listening on http://%s\n", ln.Addr())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *interval > 0 {
+		go s.watch(ctx, *interval, stdout, stderr)
+	}
+
+	srv := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// buildTree builds a tree from CSV files like the build command.
+func buildTree(specPath string, paths []string, opts mmdbwriter.Options) (*mmdbwriter.Tree, error) {
+	spec, err := csvimport.LoadSpec(specPath)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := mmdbwriter.New(opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		if _, err := csvimport.InsertFile(tree, path, spec, opts.Inserter); err != nil {
+			return nil, err
+		}
+	}
+	return tree, nil
+}
+
+// fileStamp identifies a version of a file.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFiles(paths []string) ([]fileStamp, error) {
+	stamps := make([]fileStamp, len(paths))
+	for i, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		stamps[i] = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+	}
+	return stamps, nil
+}
+
+// lookupServer serves lookups in a tree loaded from files, reloading it
+// when the files change.
+type lookupServer struct {
+	load  func() (*mmdbwriter.Tree, error)
+	paths []string
+
+	mu     sync.RWMutex
+	tree   *mmdbwriter.Tree
+	stamps []fileStamp
+}
+
+func newLookupServer(load func() (*mmdbwriter.Tree, error), paths []string) (*lookupServer, error) {
+	s := &lookupServer{load: load, paths: paths}
+	if _, err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload loads the tree again if any of the files changed since it was
+// last loaded. It reports whether the tree was reloaded. If loading fails,
+// the current tree is kept.
+func (s *lookupServer) reload() (bool, error) {
+	// The files are checked before loading them so that changes made while
+	// loading are picked up by the next reload.
+	stamps, err := statFiles(s.paths)
+	if err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	unchanged := len(stamps) == len(s.stamps)
+	for i := 0; unchanged && i < len(stamps); i++ {
+		unchanged = stamps[i].size == s.stamps[i].size && stamps[i].modTime.Equal(s.stamps[i].modTime)
+	}
+	s.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	tree, err := s.load()
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.tree = tree
+	s.stamps = stamps
+	s.mu.Unlock()
+	return true, nil
+}
+
+// watch calls reload at every interval until ctx is done.
+func (s *lookupServer) watch(ctx context.Context, interval time.Duration, stdout, stderr io.Writer) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := s.reload()
+		switch {
+		case err != nil:
+			fmt.Fprintf(stderr, "mmdbctl serve: reloading: %v\n", err)
+		case reloaded:
+			fmt.Fprintf(stdout, "reloaded %s\n", strings.Join(s.paths, ", "))
+		}
+	}
+}
+
+func (s *lookupServer) currentTree() *mmdbwriter.Tree {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree
+}
+
+func (s *lookupServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED", r.Method+" is not allowed")
+		return
+	}
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/lookup/"):
+		s.serveLookup(w, strings.TrimPrefix(r.URL.Path, "/lookup/"))
+	case r.URL.Path == "/networks":
+		s.serveNetworks(w, r.URL.Query().Get("cidr"))
+	default:
+		writeError(w, http.StatusNotFound, "NOT_FOUND", r.URL.Path+" was not found")
+	}
+}
+
+func (s *lookupServer) serveLookup(w http.ResponseWriter, address string) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		writeError(w, http.StatusBadRequest, "IP_ADDRESS_INVALID", fmt.Sprintf("%q is not a valid IP address", address))
+		return
+	}
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+	}
+
+	network, value := s.currentTree().Get(ip)
+	if value == nil {
+		writeError(w, http.StatusNotFound, "IP_ADDRESS_NOT_FOUND", fmt.Sprintf("%s has no data", ip))
+		return
+	}
+	data, err := jsonl.MarshalValue(value, false)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "SERVER_ERROR", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		IP      string          `json:"ip"`
+		Network string          `json:"network"`
+		Data    json.RawMessage `json:"data"`
+	}{ip.String(), network.String(), data})
+}
+
+func (s *lookupServer) serveNetworks(w http.ResponseWriter, cidr string) {
+	if cidr == "" {
+		writeError(w, http.StatusBadRequest, "CIDR_REQUIRED", "the cidr parameter is required")
+		return
+	}
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "CIDR_INVALID", fmt.Sprintf("%q is not a valid network", cidr))
+		return
+	}
+
+	type networkJSON struct {
+		Network string          `json:"network"`
+		Data    json.RawMessage `json:"data"`
+	}
+	networks := []networkJSON{}
+	var values []mmdbtype.DataType
+	err = s.currentTree().NetworksWithin(network, func(n *net.IPNet, value mmdbtype.DataType) error {
+		networks = append(networks, networkJSON{Network: n.String()})
+		values = append(values, value)
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "CIDR_INVALID", err.Error())
+		return
+	}
+	for i, value := range values {
+		if networks[i].Data, err = jsonl.MarshalValue(value, false); err != nil {
+			writeError(w, http.StatusInternalServerError, "SERVER_ERROR", err.Error())
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Networks []networkJSON `json:"networks"`
+	}{networks})
+}
+
+// writeError writes an error in the format of the GeoIP web services.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, struct {
+		Code  string `json:"code"`
+		Error string `json:"error"`
+	}{code, message})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	// The client may have gone away, which leaves nothing to do.
+	_ = newJSONEncoder(w).Encode(v)
+}
@@ -0,0 +1,451 @@
+// Package csvexport writes the networks of a tree and their data as CSV,
+// either in the layout of the GeoLite2 CSV databases, with blocks files
+// referring to a locations file per locale, or as a single flat file with a
+// column per value.
+package csvexport
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/internal/valueset"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// Options configures the exporters.
+type Options struct {
+	// Network restricts the export to the networks within it. See
+	// mmdbwriter.Tree.NetworksWithin.
+	Network *net.IPNet
+
+	// Locales are the locales of the locations files written by
+	// WriteGeoLite2, e.g., en and de. If empty, a file is written for each
+	// locale used in the names of the locations.
+	Locales []string
+}
+
+// CreateFunc creates the file with the provided name, e.g.,
+// Blocks-IPv4.csv, and returns a writer for it.
+type CreateFunc func(name string) (io.Writer, error)
+
+// blockColumns are the columns of the GeoLite2 City blocks files.
+var blockColumns = []string{
+	"network",
+	"geoname_id",
+	"registered_country_geoname_id",
+	"represented_country_geoname_id",
+	"is_anonymous_proxy",
+	"is_satellite_provider",
+	"postal_code",
+	"latitude",
+	"longitude",
+	"accuracy_radius",
+	"is_anycast",
+}
+
+// locationColumns are the columns of the GeoLite2 City locations files.
+var locationColumns = []string{
+	"geoname_id",
+	"locale_code",
+	"continent_code",
+	"continent_name",
+	"country_iso_code",
+	"country_name",
+	"subdivision_1_iso_code",
+	"subdivision_1_name",
+	"subdivision_2_iso_code",
+	"subdivision_2_name",
+	"city_name",
+	"metro_code",
+	"time_zone",
+	"is_in_european_union",
+}
+
+// WriteGeoLite2 writes the networks in the tree in the layout of the
+// GeoLite2 City CSV database. The networks are written to Blocks-IPv4.csv
+// and Blocks-IPv6.csv, which refer to the locations in
+// Locations-<locale>.csv by their geoname_id. Each distinct location is
+// written once to each locations file.
+//
+// A location is identified by the geoname_id of its city, country, or
+// continent, in that order. Locations without one, or whose geoname_id is
+// already used by a different location, e.g., as the names were enriched
+// for some of the networks only, are given an ID starting at 4000000000.
+//
+// It returns the number of networks written.
+func WriteGeoLite2(create CreateFunc, tree *mmdbwriter.Tree, opts Options) (int, error) {
+	blocks := map[int]*csv.Writer{}
+	for _, ipVersion := range []int{4, 6} {
+		f, err := create(fmt.Sprintf("Blocks-IPv%d.csv", ipVersion))
+		if err != nil {
+			return 0, err
+		}
+		blocks[ipVersion] = csv.NewWriter(f)
+		if err := blocks[ipVersion].Write(blockColumns); err != nil {
+			return 0, err
+		}
+	}
+
+	locs := newLocations()
+	count := 0
+	row := make([]string, len(blockColumns))
+	err := networks(tree, opts, func(network *net.IPNet, value mmdbtype.DataType) error {
+		record, _ := value.(mmdbtype.Map)
+		for i := range row {
+			row[i] = ""
+		}
+		row[0] = network.String()
+
+		var err error
+		if row[1], err = locs.id(locationOf(record)); err != nil {
+			return err
+		}
+		for i, key := range []mmdbtype.String{"registered_country", "represented_country"} {
+			if country, ok := record[key].(mmdbtype.Map); ok {
+				if row[2+i], err = locs.id(countryLocationOf(country, record)); err != nil {
+					return err
+				}
+			}
+		}
+		row[4] = formatBool(get(record, "traits", "is_anonymous_proxy"))
+		row[5] = formatBool(get(record, "traits", "is_satellite_provider"))
+		row[6] = formatScalar(get(record, "postal", "code"))
+		row[7] = formatScalar(get(record, "location", "latitude"))
+		row[8] = formatScalar(get(record, "location", "longitude"))
+		row[9] = formatScalar(get(record, "location", "accuracy_radius"))
+		row[10] = formatBool(get(record, "traits", "is_anycast"))
+
+		w := blocks[6]
+		if len(network.IP) == net.IPv4len {
+			w = blocks[4]
+		}
+		count++
+		return w.Write(row)
+	})
+	if err != nil {
+		return count, err
+	}
+	for _, ipVersion := range []int{4, 6} {
+		if err := flush(blocks[ipVersion]); err != nil {
+			return count, err
+		}
+	}
+
+	locales := opts.Locales
+	if len(locales) == 0 {
+		locales = sortedKeys(locs.locales)
+	}
+	for _, locale := range locales {
+		f, err := create("Locations-" + locale + ".csv")
+		if err != nil {
+			return count, err
+		}
+		if err := locs.write(csv.NewWriter(f), locale); err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+// networks calls fn for each network within opts.Network with a value.
+func networks(tree *mmdbwriter.Tree, opts Options, fn mmdbwriter.NetworkFunc) error {
+	if opts.Network != nil {
+		return tree.NetworksWithin(opts.Network, fn)
+	}
+	return tree.Networks(fn)
+}
+
+// syntheticIDBase is the first ID given to locations without a usable
+// geoname_id. Geoname IDs are far smaller.
+const syntheticIDBase = 4_000_000_000
+
+// locations assigns IDs to distinct locations.
+type locations struct {
+	set *valueset.Set
+	// ids are the IDs of the locations by their index in set.
+	ids  []uint64
+	used map[uint64]bool
+	// locales are the locales used in names.
+	locales map[string]bool
+}
+
+func newLocations() *locations {
+	return &locations{
+		set:     valueset.New(),
+		used:    map[uint64]bool{},
+		locales: map[string]bool{},
+	}
+}
+
+// id returns the ID of the location, or an empty string if it is empty.
+func (l *locations) id(loc mmdbtype.Map) (string, error) {
+	if len(loc) == 0 {
+		return "", nil
+	}
+	idx, added, err := l.set.Add(loc)
+	if err != nil {
+		return "", err
+	}
+	if !added {
+		return strconv.FormatUint(l.ids[idx], 10), nil
+	}
+
+	id, ok := geonameID(loc)
+	if !ok || l.used[id] {
+		id = syntheticIDBase + uint64(idx)
+	}
+	l.used[id] = true
+	l.ids = append(l.ids, id)
+
+	err = mmdbtype.Walk(loc, func(path []mmdbtype.PathElem, v mmdbtype.DataType) error {
+		if len(path) == 0 || path[len(path)-1].Key != "names" {
+			return nil
+		}
+		if names, ok := v.(mmdbtype.Map); ok {
+			for locale := range names {
+				l.locales[string(locale)] = true
+			}
+		}
+		return mmdbtype.SkipChildren
+	})
+	return strconv.FormatUint(id, 10), err
+}
+
+// write writes the locations with their names in the locale, in order by
+// ID.
+func (l *locations) write(w *csv.Writer, locale string) error {
+	order := make([]int, len(l.ids))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return l.ids[order[i]] < l.ids[order[j]] })
+
+	if err := w.Write(locationColumns); err != nil {
+		return err
+	}
+	row := make([]string, len(locationColumns))
+	for _, idx := range order {
+		loc, _ := l.set.Get(idx).(mmdbtype.Map)
+		subdivisions, _ := loc["subdivisions"].(mmdbtype.Slice)
+		var subdivision [2]mmdbtype.DataType
+		for i := 0; i < len(subdivisions) && i < len(subdivision); i++ {
+			subdivision[i] = subdivisions[i]
+		}
+
+		row[0] = strconv.FormatUint(l.ids[idx], 10)
+		row[1] = locale
+		row[2] = formatScalar(get(loc, "continent", "code"))
+		row[3] = formatScalar(get(loc, "continent", "names", locale))
+		row[4] = formatScalar(get(loc, "country", "iso_code"))
+		row[5] = formatScalar(get(loc, "country", "names", locale))
+		row[6] = formatScalar(get(subdivision[0], "iso_code"))
+		row[7] = formatScalar(get(subdivision[0], "names", locale))
+		row[8] = formatScalar(get(subdivision[1], "iso_code"))
+		row[9] = formatScalar(get(subdivision[1], "names", locale))
+		row[10] = formatScalar(get(loc, "city", "names", locale))
+		row[11] = formatScalar(get(loc, "location", "metro_code"))
+		row[12] = formatScalar(get(loc, "location", "time_zone"))
+		row[13] = formatBool(get(loc, "country", "is_in_european_union"))
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return flush(w)
+}
+
+// locationOf returns the parts of the record that describe its location
+// rather than the network, i.e., those in the locations files.
+func locationOf(record mmdbtype.Map) mmdbtype.Map {
+	loc := mmdbtype.Map{}
+	for _, key := range []mmdbtype.String{"continent", "country", "subdivisions", "city"} {
+		if v, ok := record[key]; ok {
+			loc[key] = v
+		}
+	}
+	if location, ok := record["location"].(mmdbtype.Map); ok {
+		l := mmdbtype.Map{}
+		for _, key := range []mmdbtype.String{"metro_code", "time_zone"} {
+			if v, ok := location[key]; ok {
+				l[key] = v
+			}
+		}
+		if len(l) > 0 {
+			loc["location"] = l
+		}
+	}
+	return loc
+}
+
+// countryLocationOf returns the location of a registered or represented
+// country of the record. If it is the country of the record, its continent
+// is included so that it is the same location as that of the networks in
+// the country without further details.
+func countryLocationOf(country, record mmdbtype.Map) mmdbtype.Map {
+	loc := mmdbtype.Map{"country": country}
+	if continent, ok := record["continent"]; ok && country.Equal(record["country"]) {
+		loc["continent"] = continent
+	}
+	return loc
+}
+
+// geonameID returns the geoname_id of the most specific part of the
+// location.
+func geonameID(loc mmdbtype.Map) (uint64, bool) {
+	for _, key := range []string{"city", "country", "continent"} {
+		switch id := get(loc, key, "geoname_id").(type) {
+		case mmdbtype.Uint32:
+			return uint64(id), true
+		case mmdbtype.Uint64:
+			return uint64(id), true
+		case mmdbtype.Uint16:
+			return uint64(id), true
+		}
+	}
+	return 0, false
+}
+
+// get returns the value at the path of map keys within v, or nil if there
+// is none.
+func get(v mmdbtype.DataType, path ...string) mmdbtype.DataType {
+	for _, key := range path {
+		m, ok := v.(mmdbtype.Map)
+		if !ok {
+			return nil
+		}
+		v = m[mmdbtype.String(key)]
+	}
+	return v
+}
+
+// formatScalar formats a value for a CSV cell. Nil is formatted as an
+// empty cell.
+func formatScalar(v mmdbtype.DataType) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case mmdbtype.String:
+		return string(v)
+	case mmdbtype.Bool:
+		return formatBool(v)
+	case mmdbtype.Bytes:
+		return base64.StdEncoding.EncodeToString(v)
+	case mmdbtype.Float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case mmdbtype.Float64:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64)
+	case mmdbtype.Int32:
+		return strconv.FormatInt(int64(v), 10)
+	case mmdbtype.Uint16:
+		return strconv.FormatUint(uint64(v), 10)
+	case mmdbtype.Uint32:
+		return strconv.FormatUint(uint64(v), 10)
+	case mmdbtype.Uint64:
+		return strconv.FormatUint(uint64(v), 10)
+	case *mmdbtype.Uint128:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// formatBool formats booleans as 1 or 0 like the GeoLite2 CSV databases.
+// Other values are formatted as 0.
+func formatBool(v mmdbtype.DataType) string {
+	if b, ok := v.(mmdbtype.Bool); ok && bool(b) {
+		return "1"
+	}
+	return "0"
+}
+
+// WriteFlat writes the networks in the tree to a single CSV file with a
+// network column followed by a column for each value in the data, named
+// after its path, e.g., country.names.en. Elements of arrays are named
+// after their index, e.g., subdivisions.0.iso_code. The columns are in
+// sorted order and cells without a value are empty. As the columns are
+// only known once all networks were seen, the networks are iterated twice.
+//
+// It returns the number of networks written.
+func WriteFlat(w io.Writer, tree *mmdbwriter.Tree, opts Options) (int, error) {
+	columns := map[string]int{}
+	err := networks(tree, opts, func(_ *net.IPNet, value mmdbtype.DataType) error {
+		walkLeaves(value, func(path string, _ mmdbtype.DataType) {
+			columns[path] = 0
+		})
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	header := append([]string{"network"}, sortedKeys(columns)...)
+	for i, column := range header[1:] {
+		columns[column] = i + 1
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(header); err != nil {
+		return 0, err
+	}
+	count := 0
+	row := make([]string, len(header))
+	err = networks(tree, opts, func(network *net.IPNet, value mmdbtype.DataType) error {
+		for i := range row {
+			row[i] = ""
+		}
+		row[0] = network.String()
+		walkLeaves(value, func(path string, v mmdbtype.DataType) {
+			row[columns[path]] = formatScalar(v)
+		})
+		count++
+		return cw.Write(row)
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, flush(cw)
+}
+
+// walkLeaves calls fn with the path and value of each value within v that
+// is neither a map nor an array. The record itself is named "value" if it
+// is not a map or array.
+func walkLeaves(v mmdbtype.DataType, fn func(string, mmdbtype.DataType)) {
+	var elems []string
+	// The function never returns an error.
+	_ = mmdbtype.Walk(v, func(path []mmdbtype.PathElem, v mmdbtype.DataType) error {
+		switch v.(type) {
+		case mmdbtype.Map, mmdbtype.Slice:
+			return nil
+		}
+		if len(path) == 0 {
+			fn("value", v)
+			return nil
+		}
+		elems = elems[:0]
+		for _, e := range path {
+			elems = append(elems, e.String())
+		}
+		fn(strings.Join(elems, "."), v)
+		return nil
+	})
+}
+
+func flush(w *csv.Writer) error {
+	w.Flush()
+	return w.Error()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
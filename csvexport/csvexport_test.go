@@ -0,0 +1,177 @@
+package csvexport
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func names(en, de string) mmdbtype.Map {
+	m := mmdbtype.Map{"en": mmdbtype.String(en)}
+	if de != "" {
+		m["de"] = mmdbtype.String(de)
+	}
+	return m
+}
+
+func testTree(t *testing.T) *mmdbwriter.Tree {
+	t.Helper()
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+
+	oceania := mmdbtype.Map{
+		"code":       mmdbtype.String("OC"),
+		"geoname_id": mmdbtype.Uint32(6255151),
+		"names":      names("Oceania", "Ozeanien"),
+	}
+	australia := mmdbtype.Map{
+		"iso_code":   mmdbtype.String("AU"),
+		"geoname_id": mmdbtype.Uint32(2077456),
+		"names":      names("Australia", "Australien"),
+	}
+	sydney := mmdbtype.Map{
+		"continent": oceania,
+		"country":   australia,
+		"subdivisions": mmdbtype.Slice{mmdbtype.Map{
+			"iso_code":   mmdbtype.String("NSW"),
+			"geoname_id": mmdbtype.Uint32(2155400),
+			"names":      names("New South Wales", ""),
+		}},
+		"city": mmdbtype.Map{
+			"geoname_id": mmdbtype.Uint32(2147714),
+			"names":      names("Sydney", ""),
+		},
+		"location": mmdbtype.Map{
+			"latitude":        mmdbtype.Float64(-33.8715),
+			"longitude":       mmdbtype.Float64(151.2006),
+			"accuracy_radius": mmdbtype.Uint16(100),
+			"time_zone":       mmdbtype.String("Australia/Sydney"),
+		},
+		"postal":             mmdbtype.Map{"code": mmdbtype.String("2000")},
+		"registered_country": australia,
+	}
+	// The same city with enriched names has to be given a new ID.
+	enriched := mmdbtype.Map{}
+	for k, v := range sydney {
+		enriched[k] = v
+	}
+	enriched["city"] = mmdbtype.Map{
+		"geoname_id": mmdbtype.Uint32(2147714),
+		"names":      names("Sydney (CBD)", ""),
+	}
+
+	for _, n := range []struct {
+		network string
+		value   mmdbtype.DataType
+	}{
+		{"1.0.0.0/24", sydney},
+		{"1.0.1.0/24", mmdbtype.Map{
+			"continent":          oceania,
+			"country":            australia,
+			"registered_country": australia,
+			"traits":             mmdbtype.Map{"is_anycast": mmdbtype.Bool(true)},
+		}},
+		{"1.0.2.0/24", enriched},
+		{"2600::/16", sydney},
+	} {
+		_, network, err := net.ParseCIDR(n.network)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(network, n.value))
+	}
+	return tree
+}
+
+func TestWriteGeoLite2(t *testing.T) {
+	files := map[string]*bytes.Buffer{}
+	var created []string
+	create := func(name string) (io.Writer, error) {
+		created = append(created, name)
+		files[name] = &bytes.Buffer{}
+		return files[name], nil
+	}
+
+	n, err := WriteGeoLite2(create, testTree(t), Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, []string{"Blocks-IPv4.csv", "Blocks-IPv6.csv", "Locations-de.csv", "Locations-en.csv"}, created)
+
+	assert.Equal(t, `network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,`+
+		`is_anonymous_proxy,is_satellite_provider,postal_code,latitude,longitude,accuracy_radius,is_anycast
+1.0.0.0/24,2147714,2077456,,0,0,2000,-33.8715,151.2006,100,0
+1.0.1.0/24,2077456,2077456,,0,0,,,,,1
+1.0.2.0/24,4000000002,2077456,,0,0,2000,-33.8715,151.2006,100,0
+`, files["Blocks-IPv4.csv"].String())
+	assert.Equal(t, `network,geoname_id,registered_country_geoname_id,represented_country_geoname_id,`+
+		`is_anonymous_proxy,is_satellite_provider,postal_code,latitude,longitude,accuracy_radius,is_anycast
+2600::/16,2147714,2077456,,0,0,2000,-33.8715,151.2006,100,0
+`, files["Blocks-IPv6.csv"].String())
+
+	header := `geoname_id,locale_code,continent_code,continent_name,country_iso_code,country_name,` +
+		`subdivision_1_iso_code,subdivision_1_name,subdivision_2_iso_code,subdivision_2_name,city_name,` +
+		"metro_code,time_zone,is_in_european_union\n"
+	assert.Equal(t, header+`2077456,en,OC,Oceania,AU,Australia,,,,,,,,0
+2147714,en,OC,Oceania,AU,Australia,NSW,New South Wales,,,Sydney,,Australia/Sydney,0
+4000000002,en,OC,Oceania,AU,Australia,NSW,New South Wales,,,Sydney (CBD),,Australia/Sydney,0
+`, files["Locations-en.csv"].String())
+	assert.Equal(t, header+`2077456,de,OC,Ozeanien,AU,Australien,,,,,,,,0
+2147714,de,OC,Ozeanien,AU,Australien,NSW,,,,,,Australia/Sydney,0
+4000000002,de,OC,Ozeanien,AU,Australien,NSW,,,,,,Australia/Sydney,0
+`, files["Locations-de.csv"].String())
+
+	created = nil
+	_, network, err := net.ParseCIDR("2600::/16")
+	require.NoError(t, err)
+	_, err = WriteGeoLite2(create, testTree(t), Options{Network: network, Locales: []string{"ja"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Blocks-IPv4.csv", "Blocks-IPv6.csv", "Locations-ja.csv"}, created)
+	assert.Equal(t, header+"2077456,ja,OC,,AU,,,,,,,,,0\n2147714,ja,OC,,AU,,NSW,,,,,,Australia/Sydney,0\n",
+		files["Locations-ja.csv"].String())
+}
+
+func TestWriteFlat(t *testing.T) {
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+	for _, n := range []struct {
+		network string
+		value   mmdbtype.DataType
+	}{
+		{"1.0.0.0/24", mmdbtype.Map{
+			"asn":  mmdbtype.Uint32(13335),
+			"tags": mmdbtype.Slice{mmdbtype.String("cdn"), mmdbtype.String("dns, resolver")},
+		}},
+		{"1.0.1.0/24", mmdbtype.Map{
+			"asn":     mmdbtype.Uint32(1),
+			"anycast": mmdbtype.Bool(true),
+			"score":   mmdbtype.Float32(0.5),
+		}},
+	} {
+		_, network, err := net.ParseCIDR(n.network)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(network, n.value))
+	}
+
+	var buf bytes.Buffer
+	n, err := WriteFlat(&buf, tree, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, `network,anycast,asn,score,tags.0,tags.1
+1.0.0.0/24,,13335,,cdn,"dns, resolver"
+1.0.1.0/24,1,1,0.5,,
+`, buf.String())
+
+	tree, err = mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+	_, network, err := net.ParseCIDR("1.0.0.0/24")
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(network, mmdbtype.String("scalar")))
+	buf.Reset()
+	_, err = WriteFlat(&buf, tree, Options{})
+	require.NoError(t, err)
+	assert.Equal(t, "network,value\n1.0.0.0/24,scalar\n", buf.String())
+}
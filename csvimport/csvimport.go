@@ -0,0 +1,387 @@
+// Package csvimport reads networks and their data from CSV files according
+// to a Spec describing the columns, so that databases may be built from CSV
+// files without writing any code.
+package csvimport
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// Spec describes how the rows of a CSV file map to networks and data. It is
+// usually loaded from a JSON file with LoadSpec, e.g.:
+//
+//	{
+//	  "network": "network",
+//	  "fields": [
+//	    {"column": "asn", "path": "autonomous_system_number", "type": "uint32"},
+//	    {"column": "org", "path": "autonomous_system_organization"},
+//	    {"column": "country", "path": "country.iso_code"}
+//	  ]
+//	}
+type Spec struct {
+	// Network is the column containing the network in CIDR notation or a
+	// single IP address. Either Network or both Start and End must be set.
+	Network string `json:"network,omitempty"`
+
+	// Start and End are the columns containing the first and last IP
+	// addresses of an IP range. Ranges need not be aligned to a network.
+	Start string `json:"start,omitempty"`
+	End   string `json:"end,omitempty"`
+
+	// Columns names the columns of files without a header row. If it is
+	// not set, the first row of each file is used as the header.
+	Columns []string `json:"columns,omitempty"`
+
+	// Delimiter is the field delimiter. It defaults to ",".
+	Delimiter string `json:"delimiter,omitempty"`
+
+	// Fields maps columns to values in the data inserted for each network.
+	Fields []Field `json:"fields"`
+}
+
+// Field maps a column to a value in the data inserted for each network.
+type Field struct {
+	// Column is the name of the column.
+	Column string `json:"column"`
+
+	// Path is the dot-separated path to the value in the map inserted for
+	// each network, e.g., "country.iso_code". It defaults to Column.
+	Path string `json:"path,omitempty"`
+
+	// Type is the name of the type of the value as accepted by
+	// mmdbtype.ParseValue, e.g., "uint32". It defaults to "string".
+	Type string `json:"type,omitempty"`
+
+	// KeepEmpty includes the value when the column is empty. By default,
+	// empty columns are left out of the data. Only strings may be empty.
+	KeepEmpty bool `json:"keep_empty,omitempty"`
+}
+
+// LoadSpec reads a Spec from a JSON file and validates it.
+func LoadSpec(path string) (*Spec, error) {
+	b, err := os.ReadFile(path) //nolint:gosec // reading the spec is the point
+	if err != nil {
+		return nil, err
+	}
+
+	spec := &Spec{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(spec); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("validating %s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// Validate checks the Spec for errors and fills in the defaults of its
+// fields.
+func (s *Spec) Validate() error {
+	switch {
+	case s.Network != "" && (s.Start != "" || s.End != ""):
+		return errors.New("network may not be set along with start and end")
+	case s.Network == "" && (s.Start == "" || s.End == ""):
+		return errors.New("either network or both start and end must be set")
+	}
+
+	if s.Delimiter != "" {
+		if r, size := utf8.DecodeRuneInString(s.Delimiter); size != len(s.Delimiter) || r == utf8.RuneError {
+			return fmt.Errorf("invalid delimiter %q", s.Delimiter)
+		}
+	}
+
+	paths := map[string]struct{}{}
+	for i := range s.Fields {
+		f := &s.Fields[i]
+		if f.Column == "" {
+			return fmt.Errorf("field %d has no column", i)
+		}
+		if f.Path == "" {
+			f.Path = f.Column
+		}
+		for _, k := range strings.Split(f.Path, ".") {
+			if k == "" {
+				return fmt.Errorf("invalid path %q", f.Path)
+			}
+		}
+		if f.Type == "" {
+			f.Type = mmdbtype.TypeNameString
+		}
+		typ, ok := mmdbtype.CanonicalTypeName(f.Type)
+		if !ok {
+			return fmt.Errorf("unknown type %q for %s", f.Type, f.Path)
+		}
+		if typ == mmdbtype.TypeNameMap || typ == mmdbtype.TypeNameArray {
+			return fmt.Errorf("%s may not be a %s", f.Path, typ)
+		}
+		f.Type = typ
+		if f.KeepEmpty && typ != mmdbtype.TypeNameString {
+			return fmt.Errorf("%s must be a string to keep empty values", f.Path)
+		}
+
+		if _, ok := paths[f.Path]; ok {
+			return fmt.Errorf("duplicate path %q", f.Path)
+		}
+		paths[f.Path] = struct{}{}
+	}
+	for p := range paths {
+		for prefix := p; strings.Contains(prefix, "."); {
+			prefix = prefix[:strings.LastIndex(prefix, ".")]
+			if _, ok := paths[prefix]; ok {
+				return fmt.Errorf("path %q is inside of path %q", p, prefix)
+			}
+		}
+	}
+	return nil
+}
+
+// Row is a row read from a CSV file.
+type Row struct {
+	// Network is set if the Spec has a network column.
+	Network *net.IPNet
+	// Start and End are set if the Spec has start and end columns.
+	Start net.IP
+	End   net.IP
+	// Data is the value built from the fields of the Spec.
+	Data mmdbtype.Map
+}
+
+// Insert inserts the row into the tree using the inserter.Func returned by
+// the provided generator for the row's data.
+func (r *Row) Insert(tree *mmdbwriter.Tree, f inserter.FuncGenerator) error {
+	if r.Network != nil {
+		return tree.InsertFunc(r.Network, f(r.Data))
+	}
+	return tree.InsertRangeFunc(r.Start, r.End, f(r.Data))
+}
+
+// Reader reads Rows from a CSV file.
+type Reader struct {
+	spec    *Spec
+	csv     *csv.Reader
+	columns []int
+	network int
+	start   int
+	end     int
+}
+
+// NewReader returns a Reader that reads rows from r according to the
+// validated spec. If the spec does not name the columns, the header row is
+// read immediately.
+func NewReader(r io.Reader, spec *Spec) (*Reader, error) {
+	cr := csv.NewReader(r)
+	if spec.Delimiter != "" {
+		cr.Comma, _ = utf8.DecodeRuneInString(spec.Delimiter)
+	}
+	cr.ReuseRecord = true
+
+	header := spec.Columns
+	if header == nil {
+		row, err := cr.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("missing header row")
+			}
+			return nil, fmt.Errorf("reading header row: %w", err)
+		}
+		header = append([]string(nil), row...)
+	} else {
+		cr.FieldsPerRecord = len(header)
+	}
+
+	indexes := make(map[string]int, len(header))
+	for i, c := range header {
+		indexes[strings.TrimSpace(c)] = i
+	}
+	index := func(c string) (int, error) {
+		i, ok := indexes[c]
+		if !ok {
+			return 0, fmt.Errorf("column %q not found", c)
+		}
+		return i, nil
+	}
+
+	rd := &Reader{spec: spec, csv: cr, network: -1, start: -1, end: -1}
+	var err error
+	if spec.Network != "" {
+		if rd.network, err = index(spec.Network); err != nil {
+			return nil, err
+		}
+	} else {
+		if rd.start, err = index(spec.Start); err != nil {
+			return nil, err
+		}
+		if rd.end, err = index(spec.End); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range spec.Fields {
+		i, err := index(f.Column)
+		if err != nil {
+			return nil, err
+		}
+		rd.columns = append(rd.columns, i)
+	}
+	return rd, nil
+}
+
+// Read returns the next row. It returns io.EOF when there are no more
+// rows.
+func (r *Reader) Read() (*Row, error) {
+	record, err := r.csv.Read()
+	if err != nil {
+		return nil, err
+	}
+	line, _ := r.csv.FieldPos(0)
+
+	row, err := r.parse(record)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", line, err)
+	}
+	return row, nil
+}
+
+func (r *Reader) parse(record []string) (*Row, error) {
+	field := func(i int) (string, error) {
+		if i >= len(record) {
+			return "", fmt.Errorf("expected at least %d columns but found %d", i+1, len(record))
+		}
+		return strings.TrimSpace(record[i]), nil
+	}
+
+	row := &Row{Data: mmdbtype.Map{}}
+	if r.network >= 0 {
+		s, err := field(r.network)
+		if err != nil {
+			return nil, err
+		}
+		row.Network, err = ParseNetwork(s)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		start, err := field(r.start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := field(r.end)
+		if err != nil {
+			return nil, err
+		}
+		if row.Start = net.ParseIP(start); row.Start == nil {
+			return nil, fmt.Errorf("invalid start IP address %q", start)
+		}
+		if row.End = net.ParseIP(end); row.End == nil {
+			return nil, fmt.Errorf("invalid end IP address %q", end)
+		}
+	}
+
+	for i, f := range r.spec.Fields {
+		s, err := field(r.columns[i])
+		if err != nil {
+			return nil, err
+		}
+		if s == "" && !f.KeepEmpty {
+			continue
+		}
+		v, err := mmdbtype.ParseValue(f.Type, s)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", f.Column, err)
+		}
+		SetPath(row.Data, f.Path, v)
+	}
+	return row, nil
+}
+
+// ParseNetwork parses a network in CIDR notation or a single IP address,
+// which is treated as a network containing only that address. IPv4
+// networks are returned with 4-byte IPs.
+func ParseNetwork(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid network %q", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil && !strings.Contains(s, ":") {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network %q", s)
+	}
+	return network, nil
+}
+
+// SetPath sets the value at the dot-separated path in m, creating maps for
+// the intermediate keys as needed.
+func SetPath(m mmdbtype.Map, path string, v mmdbtype.DataType) {
+	keys := strings.Split(path, ".")
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := m[mmdbtype.String(k)].(mmdbtype.Map)
+		if !ok {
+			next = mmdbtype.Map{}
+			m[mmdbtype.String(k)] = next
+		}
+		m = next
+	}
+	m[mmdbtype.String(keys[len(keys)-1])] = v
+}
+
+// InsertFile inserts the rows of the CSV file at path into the tree. It
+// returns the number of rows inserted.
+func InsertFile(
+	tree *mmdbwriter.Tree,
+	path string,
+	spec *Spec,
+	f inserter.FuncGenerator,
+) (int, error) {
+	fh, err := os.Open(path) //nolint:gosec // reading the file is the point
+	if err != nil {
+		return 0, err
+	}
+	defer fh.Close()
+
+	r, err := NewReader(fh, spec)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+
+	rows := 0
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return rows, fmt.Errorf("%s: %w", path, err)
+		}
+		if err := row.Insert(tree, f); err != nil {
+			return rows, fmt.Errorf("%s: inserting %s: %w", path, row.describe(), err)
+		}
+		rows++
+	}
+}
+
+func (r *Row) describe() string {
+	if r.Network != nil {
+		return r.Network.String()
+	}
+	return r.Start.String() + "-" + r.End.String()
+}
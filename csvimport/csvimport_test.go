@@ -0,0 +1,122 @@
+package csvimport
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	spec := &Spec{
+		Network: "network",
+		Fields: []Field{
+			{Column: "asn", Path: "autonomous_system_number", Type: "uint32"},
+			{Column: "org", Path: "autonomous_system_organization"},
+			{Column: "country", Path: "country.iso_code"},
+			{Column: "anycast", Path: "traits.is_anycast", Type: "bool"},
+		},
+	}
+	require.NoError(t, spec.Validate())
+	assert.Equal(t, "string", spec.Fields[1].Type)
+
+	input := `network,asn,org,country,anycast
+1.0.0.0/24,13335,"Cloudflare, Inc.",US,true
+2001:db8::1,64496,Example,,
+`
+	r, err := NewReader(strings.NewReader(input), spec)
+	require.NoError(t, err)
+
+	row, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0.0/24", row.Network.String())
+	assert.Equal(t, mmdbtype.Map{
+		"autonomous_system_number":       mmdbtype.Uint32(13335),
+		"autonomous_system_organization": mmdbtype.String("Cloudflare, Inc."),
+		"country":                        mmdbtype.Map{"iso_code": mmdbtype.String("US")},
+		"traits":                         mmdbtype.Map{"is_anycast": mmdbtype.Bool(true)},
+	}, row.Data)
+
+	row, err = r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::1/128", row.Network.String())
+	assert.Equal(t, mmdbtype.Map{
+		"autonomous_system_number":       mmdbtype.Uint32(64496),
+		"autonomous_system_organization": mmdbtype.String("Example"),
+	}, row.Data, "empty columns are left out")
+
+	_, err = r.Read()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReaderRanges(t *testing.T) {
+	spec := &Spec{
+		Start:     "start",
+		End:       "end",
+		Columns:   []string{"start", "end", "name"},
+		Delimiter: "\t",
+		Fields:    []Field{{Column: "name", KeepEmpty: true}},
+	}
+	require.NoError(t, spec.Validate())
+
+	r, err := NewReader(strings.NewReader("1.0.0.1\t1.0.0.9\t\n1.0.1.0\tx\ty\n"), spec)
+	require.NoError(t, err)
+
+	row, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, net.ParseIP("1.0.0.1"), row.Start)
+	assert.Equal(t, net.ParseIP("1.0.0.9"), row.End)
+	assert.Equal(t, mmdbtype.Map{"name": mmdbtype.String("")}, row.Data)
+
+	_, err = r.Read()
+	assert.EqualError(t, err, `line 2: invalid end IP address "x"`)
+}
+
+func TestSpecValidate(t *testing.T) {
+	tests := map[string]struct {
+		spec Spec
+		err  string
+	}{
+		"no network": {
+			spec: Spec{Start: "start"},
+			err:  "either network or both start and end must be set",
+		},
+		"network and range": {
+			spec: Spec{Network: "n", Start: "s", End: "e"},
+			err:  "network may not be set along with start and end",
+		},
+		"unknown type": {
+			spec: Spec{Network: "n", Fields: []Field{{Column: "a", Type: "uuid"}}},
+			err:  `unknown type "uuid" for a`,
+		},
+		"map type": {
+			spec: Spec{Network: "n", Fields: []Field{{Column: "a", Type: "map"}}},
+			err:  "a may not be a map",
+		},
+		"empty path element": {
+			spec: Spec{Network: "n", Fields: []Field{{Column: "a", Path: "a..b"}}},
+			err:  `invalid path "a..b"`,
+		},
+		"duplicate path": {
+			spec: Spec{Network: "n", Fields: []Field{{Column: "a"}, {Column: "b", Path: "a"}}},
+			err:  `duplicate path "a"`,
+		},
+		"nested path": {
+			spec: Spec{Network: "n", Fields: []Field{{Column: "a"}, {Column: "b", Path: "a.b"}}},
+			err:  `path "a.b" is inside of path "a"`,
+		},
+		"keep empty integer": {
+			spec: Spec{Network: "n", Fields: []Field{{Column: "a", Type: "uint32", KeepEmpty: true}}},
+			err:  "a must be a string to keep empty values",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			assert.EqualError(t, test.spec.Validate(), test.err)
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package mmdbwriter
+
+import (
+	"context"
+	"sync"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// encodeBatchSize is the number of values encoded by a worker at a time.
+const encodeBatchSize = 256
+
+// encodedValue is the encoding of a value in the data section.
+//
+// Whether a sub-value is written as a pointer depends on everything that
+// was written before it, so the encoding is recorded without pointers along
+// with the location of each sub-value that the dataWriter may replace with
+// a pointer. The dataWriter then writes the value, making the same
+// decisions that it would have made when writing the value itself. This
+// allows the expensive parts, i.e., serializing and hashing, to be done
+// concurrently or ahead of time while producing byte-identical output.
+type encodedValue struct {
+	buf  []byte
+	refs []encodedRef
+}
+
+// encodedRef is a sub-value passed to WriteOrWritePointer. Its encoding is
+// buf[start:end], which contains the sub-values in refs up to next.
+type encodedRef struct {
+	key   dataMapKey
+	value mmdbtype.DataType
+	start int
+	end   int
+	next  int
+}
+
+// encodingWriter records the encodings of values.
+type encodingWriter struct {
+	encoded   *encodedValue
+	keyWriter *keyWriter
+	keyOrder  mmdbtype.KeyOrder
+}
+
+func newEncodingWriter(keyOrder mmdbtype.KeyOrder) *encodingWriter {
+	return &encodingWriter{
+		// The values are held by the tree while they are being encoded.
+		keyWriter: newCachingKeyWriter(),
+		keyOrder:  keyOrder,
+	}
+}
+
+func (ew *encodingWriter) encode(v mmdbtype.DataType) (*encodedValue, error) {
+	ew.encoded = &encodedValue{}
+	defer func() { ew.encoded = nil }()
+
+	if _, err := v.WriteTo(ew); err != nil {
+		return nil, err
+	}
+	return ew.encoded, nil
+}
+
+// KeyOrder returns the order in which the dataWriter writes Map keys.
+func (ew *encodingWriter) KeyOrder() mmdbtype.KeyOrder {
+	return ew.keyOrder
+}
+
+func (ew *encodingWriter) Write(p []byte) (int, error) {
+	ew.encoded.buf = append(ew.encoded.buf, p...)
+	return len(p), nil
+}
+
+func (ew *encodingWriter) WriteByte(c byte) error {
+	ew.encoded.buf = append(ew.encoded.buf, c)
+	return nil
+}
+
+func (ew *encodingWriter) WriteString(s string) (int, error) {
+	ew.encoded.buf = append(ew.encoded.buf, s...)
+	return len(s), nil
+}
+
+func (ew *encodingWriter) WriteOrWritePointer(t mmdbtype.DataType) (int64, error) {
+	key, err := ew.keyWriter.key(t)
+	if err != nil {
+		return 0, err
+	}
+
+	e := ew.encoded
+	i := len(e.refs)
+	e.refs = append(e.refs, encodedRef{key: key, value: t, start: len(e.buf)})
+
+	size, err := t.WriteTo(ew)
+	if err != nil {
+		return size, err
+	}
+
+	e.refs[i].end = len(e.buf)
+	e.refs[i].next = len(e.refs)
+	return size, nil
+}
+
+// encodedBatch holds the encodings of consecutive values in the data
+// section.
+type encodedBatch struct {
+	indexes []uint32
+	// encoded contains the encoding of each value. Values whose encoding
+	// was cached are set before the batch is passed to a worker.
+	encoded []*encodedValue
+
+	err  error
+	done chan struct{}
+}
+
+// encodeConcurrently encodes the values at the provided indexes using the
+// provided number of workers and calls fn with each batch in order. Only a
+// limited number of batches are held in memory at a time. If cache is set,
+// cached encodings are used and new encodings are added to it.
+func encodeConcurrently(
+	ctx context.Context,
+	dm *dataMap,
+	indexes []uint32,
+	cache *encodingCache,
+	workers int,
+	keyOrder mmdbtype.KeyOrder,
+	fn func(*encodedBatch) error,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Growing the cache up front means that setting an encoding below never
+	// modifies the slice itself. As each value is only in one batch, the
+	// workers and fn then never access the same element of the cache.
+	cache.reserve(len(dm.values))
+
+	jobs := make(chan *encodedBatch)
+	pending := make(chan *encodedBatch, 2*workers)
+
+	go func() {
+		defer close(pending)
+		defer close(jobs)
+		for start := 0; start < len(indexes); start += encodeBatchSize {
+			end := start + encodeBatchSize
+			if end > len(indexes) {
+				end = len(indexes)
+			}
+			b := &encodedBatch{
+				indexes: indexes[start:end],
+				encoded: make([]*encodedValue, end-start),
+				done:    make(chan struct{}),
+			}
+			for i, idx := range b.indexes {
+				b.encoded[i] = cache.get(idx)
+			}
+			select {
+			case pending <- b:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- b:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ew := newEncodingWriter(keyOrder)
+			for b := range jobs {
+				b.err = encodeBatch(ew, dm, b)
+				close(b.done)
+			}
+		}()
+	}
+
+	var err error
+	for b := range pending {
+		select {
+		case <-b.done:
+			err = b.err
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = fn(b)
+		}
+		if err != nil {
+			break
+		}
+		for i, idx := range b.indexes {
+			cache.set(idx, b.encoded[i])
+		}
+	}
+
+	cancel()
+	wg.Wait()
+	return err
+}
+
+func encodeBatch(ew *encodingWriter, dm *dataMap, b *encodedBatch) error {
+	for i, idx := range b.indexes {
+		if b.encoded[i] != nil {
+			continue
+		}
+		encoded, err := ew.encode(dm.get(idx).data)
+		if err != nil {
+			return err
+		}
+		b.encoded[i] = encoded
+	}
+	return nil
+}
+
+// encodingCache holds the encodings of the values in a dataMap between
+// writes. As the value at an index is never modified, the encoding only
+// needs to be discarded when the value is removed. A nil encodingCache
+// caches nothing.
+type encodingCache struct {
+	encoded []*encodedValue
+}
+
+func (c *encodingCache) get(idx uint32) *encodedValue {
+	if c == nil || int(idx) >= len(c.encoded) {
+		return nil
+	}
+	return c.encoded[idx]
+}
+
+func (c *encodingCache) set(idx uint32, encoded *encodedValue) {
+	if c == nil {
+		return
+	}
+	c.reserve(int(idx) + 1)
+	c.encoded[idx] = encoded
+}
+
+// reserve grows the cache to hold at least n encodings.
+func (c *encodingCache) reserve(n int) {
+	if c == nil || n <= len(c.encoded) {
+		return
+	}
+	c.encoded = append(c.encoded, make([]*encodedValue, n-len(c.encoded))...)
+}
+
+func (c *encodingCache) clone() *encodingCache {
+	if c == nil {
+		return nil
+	}
+	return &encodingCache{encoded: append([]*encodedValue(nil), c.encoded...)}
+}
+
+// remove discards the encoding of the value at idx.
+func (c *encodingCache) remove(idx uint32) {
+	if c == nil || int(idx) >= len(c.encoded) {
+		return
+	}
+	c.encoded[idx] = nil
+}
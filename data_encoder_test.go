@@ -0,0 +1,172 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteConcurrency(t *testing.T) {
+	r := rand.New(rand.NewSource(0)) //nolint:gosec // test
+	countries := make([]mmdbtype.Map, 20)
+	for i := range countries {
+		countries[i] = mmdbtype.Map{
+			"iso_code": mmdbtype.String(fmt.Sprintf("C%d", i)),
+			"names": mmdbtype.Map{
+				"en": mmdbtype.String(fmt.Sprintf("Country %d", i)),
+				"de": mmdbtype.String(fmt.Sprintf("Land %d", i)),
+			},
+		}
+	}
+
+	build := func(opts Options) []byte {
+		opts.DatabaseType = "mmdbwriter-test"
+		opts.Description = map[string]string{"en": "Test database"}
+		opts.BuildEpoch = 1
+		tree, err := New(opts)
+		require.NoError(t, err)
+
+		r.Seed(0)
+		for i := 0; i < 5_000; i++ {
+			network := &net.IPNet{
+				IP:   net.IPv4(1, byte(i>>8), byte(i), 0).To4(),
+				Mask: net.CIDRMask(24, 32),
+			}
+			value := mmdbtype.Map{
+				"country": countries[r.Intn(len(countries))],
+				"id":      mmdbtype.Uint32(r.Intn(1_000)),
+				"tags": mmdbtype.Slice{
+					mmdbtype.String(fmt.Sprintf("tag %d", r.Intn(50))),
+					mmdbtype.String(fmt.Sprintf("tag %d", r.Intn(50))),
+				},
+			}
+			require.NoError(t, tree.Insert(network, value))
+		}
+
+		buf := &bytes.Buffer{}
+		_, err = tree.WriteTo(buf)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+
+	for _, opts := range []Options{
+		{},
+		{MapKeyOrder: mmdbtype.PriorityKeyOrder("tags", "country")},
+		{OptimizeDataSection: true},
+	} {
+		expected := build(opts)
+		for _, concurrency := range []int{2, 8} {
+			opts.WriteConcurrency = concurrency
+			assert.Equal(t, expected, build(opts), "output with %d goroutines", concurrency)
+		}
+	}
+}
+
+func TestWriteConcurrencyCanceled(t *testing.T) {
+	tree, err := New(Options{IPVersion: 4, WriteConcurrency: 4})
+	require.NoError(t, err)
+
+	for i := 0; i < 5_000; i++ {
+		network := &net.IPNet{
+			IP:   net.IPv4(1, byte(i>>8), byte(i), 0).To4(),
+			Mask: net.CIDRMask(24, 32),
+		}
+		require.NoError(t, tree.Insert(network, mmdbtype.Uint32(i)))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = tree.WriteToContext(ctx, &bytes.Buffer{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestIncrementalWrites(t *testing.T) {
+	type step struct {
+		network string
+		value   mmdbtype.DataType
+	}
+	steps := [][]step{
+		{
+			{network: "1.0.0.0/16", value: mmdbtype.Map{"a": mmdbtype.String("one")}},
+			{network: "1.1.0.0/16", value: mmdbtype.Map{"a": mmdbtype.String("two")}},
+			{network: "2.0.0.0/8", value: mmdbtype.Map{"a": mmdbtype.String("three")}},
+		},
+		// Only changes values.
+		{
+			{network: "1.1.0.0/16", value: mmdbtype.Map{"a": mmdbtype.String("four")}},
+		},
+		// Splits a network.
+		{
+			{network: "2.1.0.0/16", value: mmdbtype.Map{"a": mmdbtype.String("one")}},
+		},
+		// Merges networks.
+		{
+			{network: "1.0.0.0/15", value: mmdbtype.Map{"a": mmdbtype.String("five")}},
+		},
+	}
+
+	newTree := func(opts Options) *Tree {
+		opts.DatabaseType = "mmdbwriter-test"
+		opts.Description = map[string]string{"en": "Test database"}
+		opts.BuildEpoch = 1
+		tree, err := New(opts)
+		require.NoError(t, err)
+		return tree
+	}
+	apply := func(tree *Tree, steps []step) {
+		for _, s := range steps {
+			_, network, err := net.ParseCIDR(s.network)
+			require.NoError(t, err)
+			require.NoError(t, tree.Insert(network, s.value))
+		}
+	}
+	write := func(tree *Tree) []byte {
+		buf := &bytes.Buffer{}
+		_, err := tree.WriteTo(buf)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+
+	for _, concurrency := range []int{0, 4} {
+		incremental := newTree(Options{IncrementalWrites: true, WriteConcurrency: concurrency})
+		for i, s := range steps {
+			apply(incremental, s)
+
+			expected := newTree(Options{})
+			for _, s := range steps[:i+1] {
+				apply(expected, s)
+			}
+
+			if i == 1 {
+				assert.False(t, incremental.nodes.changed, "changing values does not change the nodes")
+			}
+			require.NoError(t, incremental.Finalize())
+			assert.Equal(t, write(expected), write(incremental), "output after step %d", i)
+
+			for idx, encoded := range incremental.dataMap.encodings.encoded {
+				if encoded != nil {
+					assert.NotZero(
+						t,
+						incremental.dataMap.get(uint32(idx)).refCount,
+						"only encodings of values in the tree are cached",
+					)
+				}
+			}
+		}
+	}
+
+	tree := newTree(Options{})
+	tx, err := tree.Begin()
+	require.NoError(t, err)
+	assert.EqualError(t, tree.Finalize(), "cannot finalize a tree with an open transaction")
+	require.NoError(t, tx.Rollback())
+	assert.NoError(t, tree.Finalize())
+}
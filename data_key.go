@@ -2,38 +2,194 @@ package mmdbwriter
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"hash"
+	"encoding/binary"
+	"hash/maphash"
+	"reflect"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
 )
 
-// keyWriter is similar to dataWriter but it will never use pointers. This
-// will produce a unique key for the type.
+// keySeed is shared by all keyWriters so that keys generated by different
+// writers in the same process may be compared.
+var keySeed = maphash.MakeSeed()
+
+// keyWriter generates a dataMapKey for a value by hashing its serialized
+// form with a fast, non-cryptographic hash. Different values may have the
+// same key, so users of the key must also check the values for equality.
+//
+// Map and Slice values are hashed structurally: the key of each Map or
+// Slice element is hashed in place of the element itself. When caching is
+// enabled, the key of each Map and Slice is remembered by identity so that
+// a value shared by many records, e.g., a country map on a loaded
+// database, is only hashed once.
 type keyWriter struct {
-	*bytes.Buffer
-	sha256 hash.Hash
+	hashers []*keyHasher
+	depth   int
+
+	// cache is only set when the values being hashed are known to be
+	// neither modified nor garbage collected during the keyWriter's
+	// lifetime, as a new value could otherwise reuse the address of an old
+	// one.
+	cache map[containerID]dataMapKey
+
+	// added holds the IDs cached since the last call to keep or discard
+	// when trackAdded is set.
+	trackAdded bool
+	added      []containerID
+}
+
+// containerID identifies a Map or Slice by the address of its backing data.
+type containerID struct {
+	ptr    uintptr
+	length int
 }
 
 func newKeyWriter() *keyWriter {
-	return &keyWriter{Buffer: &bytes.Buffer{}, sha256: sha256.New()}
+	return &keyWriter{}
 }
 
-// This is just a quick hack. I am sure there is
-// something better.
-func (kw *keyWriter) key(t mmdbtype.DataType) ([]byte, error) {
-	kw.Truncate(0)
-	kw.sha256.Reset()
-	_, err := t.WriteTo(kw)
+// newCachingKeyWriter returns a keyWriter that caches the keys of Map and
+// Slice values by identity. It must only be used on values that will not
+// be modified or freed while the keyWriter is in use.
+func newCachingKeyWriter() *keyWriter {
+	return &keyWriter{cache: map[containerID]dataMapKey{}}
+}
+
+// newStoreKeyWriter returns a caching keyWriter for the values stored in a
+// dataMap. The keys cached while hashing a value must be kept with keep if
+// the value is stored and removed with discard otherwise, and the keys of
+// a value that is removed from the dataMap must be removed with forget, as
+// the values may be freed afterward.
+func newStoreKeyWriter() *keyWriter {
+	return &keyWriter{cache: map[containerID]dataMapKey{}, trackAdded: true}
+}
+
+// keep keeps the keys cached since the last call to keep or discard.
+func (kw *keyWriter) keep() {
+	kw.added = kw.added[:0]
+}
+
+// discard removes the keys cached since the last call to keep or discard.
+func (kw *keyWriter) discard() {
+	for _, id := range kw.added {
+		delete(kw.cache, id)
+	}
+	kw.added = kw.added[:0]
+}
+
+// forget removes the cached keys of t and of the values it contains.
+func (kw *keyWriter) forget(t mmdbtype.DataType) {
+	id, cacheable := kw.containerID(t)
+	if !cacheable {
+		return
+	}
+	delete(kw.cache, id)
+	switch v := t.(type) {
+	case mmdbtype.Map:
+		for _, e := range v {
+			kw.forget(e)
+		}
+	case mmdbtype.Slice:
+		for _, e := range v {
+			kw.forget(e)
+		}
+	}
+}
+
+func (kw *keyWriter) key(t mmdbtype.DataType) (dataMapKey, error) {
+	id, cacheable := kw.containerID(t)
+	if cacheable {
+		if key, ok := kw.cache[id]; ok {
+			return key, nil
+		}
+	}
+
+	if kw.depth == len(kw.hashers) {
+		h := &keyHasher{keyWriter: kw}
+		h.SetSeed(keySeed)
+		kw.hashers = append(kw.hashers, h)
+	}
+	h := kw.hashers[kw.depth]
+	h.Reset()
+
+	kw.depth++
+	_, err := t.WriteTo(h)
+	kw.depth--
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+
+	key := dataMapKey(h.Sum64())
+	if cacheable {
+		kw.cache[id] = key
+		if kw.trackAdded {
+			kw.added = append(kw.added, id)
+		}
+	}
+	return key, nil
+}
+
+func (kw *keyWriter) containerID(t mmdbtype.DataType) (containerID, bool) {
+	if kw.cache == nil {
+		return containerID{}, false
+	}
+	switch v := t.(type) {
+	case mmdbtype.Map:
+		return containerID{ptr: reflect.ValueOf(v).Pointer(), length: len(v)}, v != nil
+	case mmdbtype.Slice:
+		// Distinct empty slices may share an address.
+		return containerID{ptr: reflect.ValueOf(v).Pointer(), length: len(v)}, len(v) > 0
+	default:
+		return containerID{}, false
+	}
+}
+
+// keyHasher is the writer used by keyWriter. It never uses pointers.
+type keyHasher struct {
+	maphash.Hash
+	keyWriter *keyWriter
+	keyBuf    [8]byte
+}
+
+func (h *keyHasher) WriteOrWritePointer(t mmdbtype.DataType) (int64, error) {
+	switch t.(type) {
+	case mmdbtype.Map, mmdbtype.Slice:
+		key, err := h.keyWriter.key(t)
+		if err != nil {
+			return 0, err
+		}
+		binary.BigEndian.PutUint64(h.keyBuf[:], uint64(key))
+		n, err := h.Write(h.keyBuf[:])
+		return int64(n), err
+	default:
+		return t.WriteTo(h)
+	}
+}
+
+// sameValue checks whether two values with the same key are in fact the
+// same value. Equal is used as it is cheap, but we fall back to comparing
+// the serialized values as Equal is false for some values with identical
+// serializations, e.g., NaN floats.
+func sameValue(a, b mmdbtype.DataType) bool {
+	if a.Equal(b) {
+		return true
+	}
+	aw := &flatWriter{Buffer: &bytes.Buffer{}}
+	bw := &flatWriter{Buffer: &bytes.Buffer{}}
+	if _, err := a.WriteTo(aw); err != nil {
+		return false
 	}
-	if _, err := kw.WriteTo(kw.sha256); err != nil {
-		return nil, err
+	if _, err := b.WriteTo(bw); err != nil {
+		return false
 	}
-	return kw.sha256.Sum(nil), nil
+	return bytes.Equal(aw.Bytes(), bw.Bytes())
+}
+
+// flatWriter serializes a value without using pointers.
+type flatWriter struct {
+	*bytes.Buffer
 }
 
-func (kw *keyWriter) WriteOrWritePointer(t mmdbtype.DataType) (int64, error) {
-	return t.WriteTo(kw)
+func (fw *flatWriter) WriteOrWritePointer(t mmdbtype.DataType) (int64, error) {
+	return t.WriteTo(fw)
 }
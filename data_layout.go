@@ -0,0 +1,201 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// minPointerSize is the size of a pointer to an offset in the first 2 KiB
+// of the data section.
+const minPointerSize = 2
+
+// layoutPlanner decides the order in which the values in the data section
+// are written so as to maximize the space saved by pointers.
+//
+// The data writer replaces a repeated sub-value, e.g., an identical
+// "country" map shared by many city records, with a pointer to where it was
+// first written. Pointers to the start of the data section are the
+// shortest, so we want the sub-values that are repeated the most to be
+// written first. The MaxMind DB spec does not allow data that is not
+// referenced by the search tree, so rather than writing the sub-values on
+// their own, we first write the smallest record containing each frequent
+// sub-value, in order of the space that sharing the sub-value saves. The
+// remaining records are written in search tree order.
+type layoutPlanner struct {
+	keyWriter *keyWriter
+	subValues map[dataMapKey]*subValueStats
+
+	// keyOrder is the order in which the data writer writes Map keys.
+	keyOrder mmdbtype.KeyOrder
+
+	// These are set while measuring a record.
+	sizer   *sizeWriter
+	touched []*subValueStats
+}
+
+type subValueStats struct {
+	value mmdbtype.DataType
+	size  int64
+	count int
+	// container is the position of the smallest record that contains the
+	// sub-value.
+	container     int
+	containerSize int64
+	flat          []byte
+}
+
+// savings returns the number of bytes saved if every occurrence of the
+// sub-value after the first is replaced by the shortest pointer.
+func (s *subValueStats) savings() int64 {
+	return int64(s.count-1) * (s.size - minPointerSize)
+}
+
+func newLayoutPlanner(keyOrder mmdbtype.KeyOrder) *layoutPlanner {
+	lp := &layoutPlanner{
+		// The values are held by the tree while planning.
+		keyWriter: newCachingKeyWriter(),
+		subValues: map[dataMapKey]*subValueStats{},
+		keyOrder:  keyOrder,
+	}
+	lp.sizer = &sizeWriter{planner: lp}
+	return lp
+}
+
+// plan returns the positions in values in the order in which they should
+// be written. values must be in search tree order and must not contain
+// duplicates. The result only depends on the values and their order, which
+// keeps the output reproducible.
+func (lp *layoutPlanner) plan(values []mmdbtype.DataType) ([]int, error) {
+	for i, v := range values {
+		lp.touched = lp.touched[:0]
+		size, err := v.WriteTo(lp.sizer)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range lp.touched {
+			if s.container < 0 || size < s.containerSize {
+				s.container = i
+				s.containerSize = size
+			}
+		}
+	}
+
+	frequent := make([]*subValueStats, 0, len(lp.subValues))
+	for _, s := range lp.subValues {
+		if s.count > 1 && s.size > minPointerSize {
+			frequent = append(frequent, s)
+		}
+	}
+	var flatErr error
+	sort.Slice(frequent, func(i, j int) bool {
+		a, b := frequent[i], frequent[j]
+		if a.savings() != b.savings() {
+			return a.savings() > b.savings()
+		}
+		// Ties are broken by the serialized values as the keys are not
+		// stable across processes.
+		aFlat, err := a.flatBytes()
+		if err != nil {
+			flatErr = err
+		}
+		bFlat, err := b.flatBytes()
+		if err != nil {
+			flatErr = err
+		}
+		return bytes.Compare(aFlat, bFlat) < 0
+	})
+	if flatErr != nil {
+		return nil, flatErr
+	}
+
+	order := make([]int, 0, len(values))
+	placed := make([]bool, len(values))
+	for _, s := range frequent {
+		if placed[s.container] {
+			continue
+		}
+		placed[s.container] = true
+		order = append(order, s.container)
+	}
+	for i := range values {
+		if !placed[i] {
+			order = append(order, i)
+		}
+	}
+	return order, nil
+}
+
+func (s *subValueStats) flatBytes() ([]byte, error) {
+	if s.flat == nil {
+		fw := &flatWriter{Buffer: &bytes.Buffer{}}
+		if _, err := s.value.WriteTo(fw); err != nil {
+			return nil, err
+		}
+		s.flat = fw.Bytes()
+	}
+	return s.flat, nil
+}
+
+// sizeWriter measures the size of a record as the data writer would write
+// it if each repeated sub-value were replaced by a pointer, without
+// writing anything. It records the sub-values that it sees in the planner.
+type sizeWriter struct {
+	planner *layoutPlanner
+}
+
+// KeyOrder returns the order in which the data writer writes Map keys so
+// that sub-values are seen in the same order.
+func (sw *sizeWriter) KeyOrder() mmdbtype.KeyOrder {
+	return sw.planner.keyOrder
+}
+
+func (sw *sizeWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (sw *sizeWriter) WriteByte(byte) error {
+	return nil
+}
+
+func (sw *sizeWriter) WriteString(s string) (int, error) {
+	return len(s), nil
+}
+
+func (sw *sizeWriter) WriteOrWritePointer(t mmdbtype.DataType) (int64, error) {
+	lp := sw.planner
+	key, err := lp.keyWriter.key(t)
+	if err != nil {
+		return 0, err
+	}
+
+	s, ok := lp.subValues[key]
+	if ok && sameValue(s.value, t) {
+		// As the data writer would write a pointer here, the sub-values of
+		// this value are not counted again.
+		s.count++
+		lp.touched = append(lp.touched, s)
+		if s.size <= minPointerSize {
+			return s.size, nil
+		}
+		return minPointerSize, nil
+	}
+
+	size, err := t.WriteTo(sw)
+	if err != nil || ok {
+		// In the unlikely event of a key collision, we don't track the
+		// second value.
+		return size, err
+	}
+
+	s = &subValueStats{
+		value:     t,
+		size:      size,
+		count:     1,
+		container: -1,
+	}
+	lp.subValues[key] = s
+	lp.touched = append(lp.touched, s)
+	return size, nil
+}
@@ -0,0 +1,99 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptimizeDataSection(t *testing.T) {
+	type insert struct {
+		network *net.IPNet
+		value   mmdbtype.DataType
+	}
+	var inserts []insert
+
+	// Records with no shared sub-values come first in the search tree so
+	// that, without optimization, the shared country map is written beyond
+	// the reach of the shortest pointers.
+	for i := 0; i < 200; i++ {
+		inserts = append(inserts, insert{
+			network: &net.IPNet{
+				IP:   net.IPv4(1, 0, byte(i), 0).To4(),
+				Mask: net.CIDRMask(24, 32),
+			},
+			value: mmdbtype.String(fmt.Sprintf("unique %d %s", i, strings.Repeat("x", 20))),
+		})
+	}
+	country := mmdbtype.Map{
+		"iso_code": mmdbtype.String("DE"),
+		"names": mmdbtype.Map{
+			"de": mmdbtype.String("Deutschland"),
+			"en": mmdbtype.String("Germany"),
+			"fr": mmdbtype.String("Allemagne"),
+		},
+	}
+	for i := 0; i < 500; i++ {
+		inserts = append(inserts, insert{
+			network: &net.IPNet{
+				IP:   net.IPv4(2, byte(i>>8), byte(i), 0).To4(),
+				Mask: net.CIDRMask(24, 32),
+			},
+			value: mmdbtype.Map{
+				"country": country,
+				"id":      mmdbtype.Uint32(i),
+			},
+		})
+	}
+
+	build := func(optimize bool, seed int64) []byte {
+		tree, err := New(
+			Options{
+				BuildEpoch:          1,
+				DatabaseType:        "mmdbwriter-test",
+				Description:         map[string]string{"en": "Test database"},
+				OptimizeDataSection: optimize,
+				Reproducible:        true,
+			},
+		)
+		require.NoError(t, err)
+
+		for _, i := range rand.New(rand.NewSource(seed)).Perm(len(inserts)) { //nolint:gosec // test
+			require.NoError(t, tree.Insert(inserts[i].network, inserts[i].value))
+		}
+
+		buf := &bytes.Buffer{}
+		_, err = tree.WriteTo(buf)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+
+	plain := build(false, 0)
+	optimized := build(true, 0)
+	assert.Less(t, len(optimized), len(plain))
+
+	for seed := int64(1); seed < 5; seed++ {
+		assert.Equal(t, optimized, build(true, seed), "output for insertion order seed %d", seed)
+	}
+
+	plainReader, err := maxminddb.FromBytes(plain)
+	require.NoError(t, err)
+	optimizedReader, err := maxminddb.FromBytes(optimized)
+	require.NoError(t, err)
+	require.NoError(t, optimizedReader.Verify())
+
+	for _, ins := range inserts {
+		var expected, actual any
+		require.NoError(t, plainReader.Lookup(ins.network.IP, &expected))
+		require.NoError(t, optimizedReader.Lookup(ins.network.IP, &actual))
+		assert.Equal(t, expected, actual, "value for %s", ins.network)
+	}
+}
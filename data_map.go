@@ -1,8 +1,12 @@
 package mmdbwriter
 
-import "github.com/maxmind/mmdbwriter/mmdbtype"
+import (
+	"errors"
 
-type dataMapKey string
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+type dataMapKey uint64
 
 // Please note, if you change the order of these fields, please check
 // alignment as we end up storing quite a few in memory.
@@ -17,54 +21,194 @@ type dataMapValue struct {
 }
 
 // dataMap is used to deduplicate data inserted into the tree to reduce
-// memory usage using keys generated by keyWriter.
+// memory usage using keys generated by keyWriter. Records refer to values by
+// their index in the dataMap.
+//
+// The keys of the Map and Slice values in the stored values are cached, as
+// values must not be modified once inserted. This makes storing values
+// that share sub-values, e.g., the records of a loaded database, cheaper.
 type dataMap struct {
-	data      map[dataMapKey]*dataMapValue
-	keyWriter *keyWriter
+	values []dataMapValue
+	// free contains the indexes of unused values that may be reused.
+	free []uint32
+
+	index map[dataMapKey]uint32
+	// collisions holds the indexes of values whose key is the same as that
+	// of a different value in index. With a 64-bit hash, this is expected
+	// to be empty.
+	collisions map[dataMapKey][]uint32
+	keyWriter  *keyWriter
+
+	// encodings is set when the encodings of values are kept between
+	// writes.
+	encodings *encodingCache
 }
 
 func newDataMap() *dataMap {
 	return &dataMap{
-		data:      map[dataMapKey]*dataMapValue{},
-		keyWriter: newKeyWriter(),
+		index:      map[dataMapKey]uint32{},
+		collisions: map[dataMapKey][]uint32{},
+		keyWriter:  newStoreKeyWriter(),
 	}
 }
 
-// store stores the value in the dataMap and returns the dataMapValue for it.
-// If the value is already in the dataMap, the reference count for it is
-// incremented.
-func (dm *dataMap) store(v mmdbtype.DataType) (*dataMapValue, error) {
+// get returns the value at idx. The returned pointer is only valid until
+// the next value is stored.
+func (dm *dataMap) get(idx uint32) *dataMapValue {
+	return &dm.values[idx]
+}
+
+// len returns the number of distinct values in the dataMap.
+func (dm *dataMap) len() int {
+	return len(dm.values) - len(dm.free)
+}
+
+// store stores the value in the dataMap and returns its index. If the value
+// is already in the dataMap, the reference count for it is incremented.
+func (dm *dataMap) store(v mmdbtype.DataType, j *journal) (uint32, error) {
 	key, err := dm.keyWriter.key(v)
 	if err != nil {
-		return nil, err
+		dm.keyWriter.discard()
+		return 0, err
 	}
 
-	dmv, ok := dm.data[dataMapKey(key)]
+	idx, ok := dm.lookup(key, v)
+	if ok {
+		// v may be freed, so only the keys of the stored value are kept.
+		dm.keyWriter.discard()
+		dm.values[idx].refCount++
+		j.storedValue(idx, false)
+		return idx, nil
+	}
+
+	// When there is a journal, we always append the value so that storing
+	// it may be undone by truncating values.
+	if j == nil && len(dm.free) > 0 {
+		idx = dm.free[len(dm.free)-1]
+		dm.free = dm.free[:len(dm.free)-1]
+	} else {
+		if len(dm.values) > maxRecordIndex {
+			dm.keyWriter.discard()
+			return 0, errors.New("the tree has exceeded the maximum number of distinct values")
+		}
+		idx = uint32(len(dm.values))
+		dm.values = append(dm.values, dataMapValue{})
+	}
+	dm.values[idx] = dataMapValue{
+		data:     v,
+		key:      key,
+		refCount: 1,
+	}
+	dm.keyWriter.keep()
+	dm.addIndex(idx)
+	j.storedValue(idx, true)
+
+	return idx, nil
+}
+
+// addRef adds a reference to the value at idx.
+func (dm *dataMap) addRef(idx uint32, j *journal) {
+	dm.values[idx].refCount++
+	j.storedValue(idx, false)
+}
+
+func (dm *dataMap) lookup(key dataMapKey, v mmdbtype.DataType) (uint32, bool) {
+	idx, ok := dm.index[key]
 	if !ok {
-		dmKey := dataMapKey(key)
-		dmv = &dataMapValue{
-			key:  dmKey,
-			data: v,
+		return 0, false
+	}
+	if sameValue(dm.values[idx].data, v) {
+		return idx, true
+	}
+	for _, idx := range dm.collisions[key] {
+		if sameValue(dm.values[idx].data, v) {
+			return idx, true
 		}
-		dm.data[dmKey] = dmv
 	}
+	return 0, false
+}
 
-	dmv.refCount++
+func (dm *dataMap) addIndex(idx uint32) {
+	key := dm.values[idx].key
+	if _, ok := dm.index[key]; ok {
+		dm.collisions[key] = append(dm.collisions[key], idx)
+		return
+	}
+	dm.index[key] = idx
+}
 
-	return dmv, nil
+func (dm *dataMap) removeIndex(idx uint32) {
+	key := dm.values[idx].key
+	collisions := dm.collisions[key]
+	if dm.index[key] == idx {
+		if len(collisions) == 0 {
+			delete(dm.index, key)
+			return
+		}
+		dm.index[key] = collisions[0]
+		collisions = collisions[1:]
+	} else {
+		for i, c := range collisions {
+			if c == idx {
+				collisions = append(collisions[:i:i], collisions[i+1:]...)
+				break
+			}
+		}
+	}
+	if len(collisions) == 0 {
+		delete(dm.collisions, key)
+	} else {
+		dm.collisions[key] = collisions
+	}
 }
 
 // remove removes a reference to the value. If the reference count
-// drops to zero, the value is removed from the dataMap.
-func (dm *dataMap) remove(v *dataMapValue) {
-	// This is here mostly so that we don't have to guard against it
-	// elsewhere.
-	if v == nil {
+// drops to zero, the value is removed from the dataMap. If j is set, the
+// index is only reused once the transaction is committed.
+func (dm *dataMap) remove(idx uint32, j *journal) {
+	v := &dm.values[idx]
+	v.refCount--
+
+	if v.refCount != 0 {
+		j.removedValue(idx, false)
 		return
 	}
-	v.refCount--
 
-	if v.refCount == 0 {
-		delete(dm.data, v.key)
+	dm.removeIndex(idx)
+	j.removedValue(idx, true)
+	if j == nil {
+		dm.release(idx)
+	}
+}
+
+// release makes the unused value at idx available for reuse.
+func (dm *dataMap) release(idx uint32) {
+	// We clear the value so that the data may be garbage collected.
+	dm.keyWriter.forget(dm.values[idx].data)
+	dm.values[idx] = dataMapValue{}
+	dm.encodings.remove(idx)
+	dm.free = append(dm.free, idx)
+}
+
+// clone returns a copy of the dataMap. As records refer to values by
+// index, the copy may be used by a copy of the records without any
+// translation.
+func (dm *dataMap) clone() *dataMap {
+	newDM := &dataMap{
+		values:     make([]dataMapValue, len(dm.values)),
+		free:       make([]uint32, len(dm.free)),
+		index:      make(map[dataMapKey]uint32, len(dm.index)),
+		collisions: make(map[dataMapKey][]uint32, len(dm.collisions)),
+		keyWriter:  newStoreKeyWriter(),
+		encodings:  dm.encodings.clone(),
+	}
+	copy(newDM.values, dm.values)
+	copy(newDM.free, dm.free)
+	for k, v := range dm.index {
+		newDM.index[k] = v
+	}
+	for k, v := range dm.collisions {
+		newDM.collisions[k] = append([]uint32(nil), v...)
 	}
+	return newDM
 }
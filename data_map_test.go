@@ -1,6 +1,7 @@
 package mmdbwriter
 
 import (
+	"math"
 	"testing"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
@@ -13,36 +14,142 @@ func TestDataMap(t *testing.T) {
 
 	dm := newDataMap()
 
-	dmv, err := dm.store(v)
+	idx, err := dm.store(v, nil)
+	require.NoError(t, err)
+
+	key, err := newKeyWriter().key(v)
 	require.NoError(t, err)
 
 	assert.Equal(
 		t,
 		&dataMapValue{
-			data: v,
-			key: "\x87\x02\xf53\x8b\x96\xfdǻQ\x97\x9c\xe2\xcc\\\xda\xf2\xb1\xd7" +
-				"\xc1L\xc5l\xfd\x83\xfc\x97\xd6\x03\xf5\xedr",
+			data:     v,
+			key:      key,
 			refCount: 1,
 		},
-		dmv,
+		dm.get(idx),
 	)
 
-	mapDMV := dm.data[dmv.key]
+	assert.Equal(t, idx, dm.index[key])
+
+	idx2, err := dm.store(v, nil)
+	require.NoError(t, err)
+	assert.Equal(t, idx, idx2)
+
+	assert.Equal(t, uint32(2), dm.get(idx).refCount, "refCount incremented on store")
 
-	assert.Equal(t, dmv, mapDMV)
+	dm.remove(idx, nil)
 
-	dmv, err = dm.store(v)
+	assert.Equal(t, uint32(1), dm.get(idx).refCount, "refCount decremented on remove")
+
+	dm.remove(idx, nil)
+	_, ok := dm.index[key]
+	assert.False(t, ok, "map value removed when refCount drops to 0")
+	assert.Equal(t, []uint32{idx}, dm.free, "index is reused")
+	assert.Equal(t, 0, dm.len())
+
+	idx3, err := dm.store(mmdbtype.String("other"), nil)
 	require.NoError(t, err)
+	assert.Equal(t, idx, idx3)
+}
+
+func TestDataMapCollisions(t *testing.T) {
+	dm := newDataMap()
 
-	assert.Equal(t, uint32(2), dmv.refCount, "refCount incremented on store")
+	// We can't easily find two values whose hashes collide, so we add
+	// values with the same key directly.
+	dm.values = []dataMapValue{
+		{data: mmdbtype.String("a"), key: 1, refCount: 1},
+		{data: mmdbtype.String("b"), key: 1, refCount: 1},
+		{data: mmdbtype.String("c"), key: 1, refCount: 1},
+	}
+	for i := range dm.values {
+		dm.addIndex(uint32(i))
+	}
 
-	dm.remove(dmv)
+	lookup := func(s string) int {
+		idx, ok := dm.lookup(1, mmdbtype.String(s))
+		if !ok {
+			return -1
+		}
+		return int(idx)
+	}
 
-	mapDMV = dm.data[dmv.key]
+	assert.Equal(t, 0, lookup("a"))
+	assert.Equal(t, 1, lookup("b"))
+	assert.Equal(t, 2, lookup("c"))
+	assert.Equal(t, -1, lookup("d"))
 
-	assert.Equal(t, uint32(1), mapDMV.refCount, "refCount decremented on remove")
+	dm.remove(1, nil)
+	assert.Equal(t, -1, lookup("b"))
+	assert.Equal(t, 2, lookup("c"))
 
-	dm.remove(dmv)
-	_, ok := dm.data[dmv.key]
-	assert.False(t, ok, "map value removed when refCount drops to 0")
+	dm.remove(0, nil)
+	assert.Equal(t, uint32(2), dm.index[1], "colliding value is promoted")
+	assert.Empty(t, dm.collisions)
+}
+
+func TestDataMapNaN(t *testing.T) {
+	dm := newDataMap()
+
+	// NaN is not Equal to itself, but values containing it must still be
+	// deduplicated.
+	first, err := dm.store(mmdbtype.Map{"nan": mmdbtype.Float64(math.NaN())}, nil)
+	require.NoError(t, err)
+	second, err := dm.store(mmdbtype.Map{"nan": mmdbtype.Float64(math.NaN())}, nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, first, second)
+	assert.Equal(t, 1, dm.len())
+}
+
+func TestKeyWriterCache(t *testing.T) {
+	country := mmdbtype.Map{"iso_code": mmdbtype.String("US")}
+	record := mmdbtype.Map{"country": country}
+
+	key, err := newKeyWriter().key(record)
+	require.NoError(t, err)
+
+	kw := newCachingKeyWriter()
+	cachedKey, err := kw.key(record)
+	require.NoError(t, err)
+	assert.Equal(t, key, cachedKey, "caching does not change the key")
+	assert.Len(t, kw.cache, 2, "both the record and the nested map are cached")
+
+	otherKey, err := kw.key(mmdbtype.Map{"country": mmdbtype.Map{"iso_code": mmdbtype.String("CA")}})
+	require.NoError(t, err)
+	assert.NotEqual(t, key, otherKey)
+}
+
+func TestDataMapKeyCache(t *testing.T) {
+	dm := newDataMap()
+
+	country := mmdbtype.Map{"iso_code": mmdbtype.String("US")}
+	first := mmdbtype.Map{"country": country, "asn": mmdbtype.Uint32(1)}
+	second := mmdbtype.Map{"country": country, "asn": mmdbtype.Uint32(2)}
+
+	firstIdx, err := dm.store(first, nil)
+	require.NoError(t, err)
+	_, err = dm.store(second, nil)
+	require.NoError(t, err)
+	assert.Len(t, dm.keyWriter.cache, 3, "the keys of the stored values and the shared map are cached")
+
+	// An equal value that is not stored may be freed, so its keys are not
+	// kept.
+	idx, err := dm.store(mmdbtype.Map{
+		"country": mmdbtype.Map{"iso_code": mmdbtype.String("US")},
+		"asn":     mmdbtype.Uint32(1),
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, firstIdx, idx)
+	assert.Len(t, dm.keyWriter.cache, 3)
+
+	dm.remove(firstIdx, nil)
+	dm.remove(firstIdx, nil)
+	assert.Len(t, dm.keyWriter.cache, 1, "the keys of the removed value and its maps are forgotten")
+
+	// The shared map is hashed again when needed.
+	_, err = dm.store(first, nil)
+	require.NoError(t, err)
+	assert.Len(t, dm.keyWriter.cache, 3)
 }
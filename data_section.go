@@ -2,11 +2,17 @@ package mmdbwriter
 
 import (
 	"bytes"
+	"io"
+	"math"
 
 	"github.com/maxmind/mmdbwriter/mmdbtype"
 )
 
+// maxPointer is the largest offset that a pointer may refer to.
+const maxPointer = math.MaxUint32
+
 type writtenType struct {
+	value   mmdbtype.DataType
 	pointer mmdbtype.Pointer
 	size    int64
 }
@@ -17,42 +23,82 @@ type dataWriter struct {
 	offsets     map[dataMapKey]writtenType
 	keyWriter   *keyWriter
 	usePointers bool
+	keyOrder    mmdbtype.KeyOrder
 }
 
 func newDataWriter(dataMap *dataMap, usePointers bool) *dataWriter {
 	return &dataWriter{
-		Buffer:      &bytes.Buffer{},
-		dataMap:     dataMap,
-		offsets:     map[dataMapKey]writtenType{},
-		keyWriter:   newKeyWriter(),
+		Buffer:  &bytes.Buffer{},
+		dataMap: dataMap,
+		offsets: map[dataMapKey]writtenType{},
+		// The values written are held by the tree or the metadata map for
+		// the lifetime of the dataWriter, so we may cache their keys.
+		keyWriter:   newCachingKeyWriter(),
 		usePointers: usePointers,
 	}
 }
 
+// KeyOrder returns the order in which Map keys are written. A nil KeyOrder
+// writes them in order by key value.
+func (dw *dataWriter) KeyOrder() mmdbtype.KeyOrder {
+	return dw.keyOrder
+}
+
+// dataTypeWriter is the writer passed to mmdbtype.DataType's WriteTo.
+type dataTypeWriter interface {
+	io.Writer
+	WriteByte(byte) error
+	WriteString(string) (int, error)
+	WriteOrWritePointer(mmdbtype.DataType) (int64, error)
+}
+
+// maybeWrite writes the value unless it was already written and returns
+// its offset.
 func (dw *dataWriter) maybeWrite(value *dataMapValue) (int, error) {
+	return dw.maybeWriteTo(dw, value)
+}
+
+// maybeWriteTo is the same as maybeWrite, except that the value is written
+// to w, which must write to dw.
+func (dw *dataWriter) maybeWriteTo(w dataTypeWriter, value *dataMapValue) (int, error) {
 	written, ok := dw.offsets[value.key]
-	if ok {
+	if ok && sameValue(written.value, value.data) {
 		return int(written.pointer), nil
 	}
 
 	offset := dw.Len()
-	size, err := value.data.WriteTo(dw)
+	size, err := value.data.WriteTo(w)
 	if err != nil {
 		return 0, err
 	}
 
-	written = writtenType{
-		pointer: mmdbtype.Pointer(offset),
-		size:    size,
+	// In the unlikely event of a key collision, the value written first
+	// keeps the key. The value written here will not be reused, which
+	// results in a larger but still valid database.
+	if !ok {
+		dw.addOffset(value.key, value.data, offset, size)
 	}
 
-	dw.offsets[value.key] = written
+	return offset, nil
+}
 
-	return int(written.pointer), nil
+// addOffset records where a value was written so that it may be reused.
+// Values beyond the reach of a pointer are not recorded. Such a data
+// section is too large to be referred to by the search tree, which is
+// reported before the database is written.
+func (dw *dataWriter) addOffset(key dataMapKey, value mmdbtype.DataType, offset int, size int64) {
+	if int64(offset) > maxPointer {
+		return
+	}
+	dw.offsets[key] = writtenType{
+		value:   value,
+		pointer: mmdbtype.Pointer(offset),
+		size:    size,
+	}
 }
 
 func (dw *dataWriter) WriteOrWritePointer(t mmdbtype.DataType) (int64, error) {
-	keyBytes, err := dw.keyWriter.key(t)
+	key, err := dw.keyWriter.key(t)
 	if err != nil {
 		return 0, err
 	}
@@ -60,18 +106,13 @@ func (dw *dataWriter) WriteOrWritePointer(t mmdbtype.DataType) (int64, error) {
 	var ok bool
 	if dw.usePointers {
 		var written writtenType
-		written, ok = dw.offsets[dataMapKey(keyBytes)]
-		if ok && written.size > written.pointer.WrittenSize() {
+		written, ok = dw.offsets[key]
+		if ok && written.size > written.pointer.WrittenSize() && sameValue(written.value, t) {
 			// Only use a pointer if it would take less space than writing the
 			// type again.
 			return written.pointer.WriteTo(dw)
 		}
 	}
-	// We can't use the pointers[dataMapKey(keyBytes)] optimization to
-	// avoid an allocation below as the backing buffer for key may change when
-	// we call t.WriteTo. That said, this is the less common code path
-	// so it doesn't matter too much.
-	key := dataMapKey(keyBytes)
 
 	// TODO: A possible optimization here for simple types would be to just
 	// write key to the dataWriter. This won't necessarily work for Map and
@@ -84,9 +125,72 @@ func (dw *dataWriter) WriteOrWritePointer(t mmdbtype.DataType) (int64, error) {
 		return size, err
 	}
 
-	dw.offsets[key] = writtenType{
-		pointer: mmdbtype.Pointer(offset),
-		size:    size,
-	}
+	dw.addOffset(key, t, offset, size)
 	return size, nil
 }
+
+// writeEncoded writes the value using its encoding as maybeWrite would.
+func (dw *dataWriter) writeEncoded(value *dataMapValue, encoded *encodedValue) (int, error) {
+	written, ok := dw.offsets[value.key]
+	if ok && sameValue(written.value, value.data) {
+		return int(written.pointer), nil
+	}
+
+	offset := dw.Len()
+	err := dw.writeEncodedRange(encoded, 0, len(encoded.buf), 0, len(encoded.refs))
+	if err != nil {
+		return 0, err
+	}
+
+	if !ok {
+		dw.addOffset(value.key, value.data, offset, int64(dw.Len()-offset))
+	}
+	return offset, nil
+}
+
+// writeEncodedRange writes e.buf[start:end], which contains the sub-values
+// in e.refs[refStart:refEnd].
+func (dw *dataWriter) writeEncodedRange(
+	e *encodedValue,
+	start, end int,
+	refStart, refEnd int,
+) error {
+	for i := refStart; i < refEnd; {
+		r := &e.refs[i]
+		if _, err := dw.Write(e.buf[start:r.start]); err != nil {
+			return err
+		}
+		if err := dw.writeEncodedRef(e, i); err != nil {
+			return err
+		}
+		start = r.end
+		i = r.next
+	}
+	_, err := dw.Write(e.buf[start:end])
+	return err
+}
+
+// writeEncodedRef writes the sub-value at e.refs[i] as WriteOrWritePointer
+// would.
+func (dw *dataWriter) writeEncodedRef(e *encodedValue, i int) error {
+	r := &e.refs[i]
+
+	var ok bool
+	if dw.usePointers {
+		var written writtenType
+		written, ok = dw.offsets[r.key]
+		if ok && written.size > written.pointer.WrittenSize() && sameValue(written.value, r.value) {
+			_, err := written.pointer.WriteTo(dw)
+			return err
+		}
+	}
+
+	offset := dw.Len()
+	err := dw.writeEncodedRange(e, r.start, r.end, i+1, r.next)
+	if err != nil || ok {
+		return err
+	}
+
+	dw.addOffset(r.key, r.value, offset, int64(dw.Len()-offset))
+	return nil
+}
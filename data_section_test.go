@@ -17,18 +17,18 @@ func TestDisablingPointers(t *testing.T) {
 	}
 	dm := newDataMap()
 
-	key, err := dm.store(v)
+	idx, err := dm.store(v, nil)
 	require.NoError(t, err)
 
 	usePointers := true
 	pointerWriter := newDataWriter(dm, usePointers)
 
-	_, err = pointerWriter.maybeWrite(key)
+	_, err = pointerWriter.maybeWrite(dm.get(idx))
 	require.NoError(t, err)
 
 	usePointers = false
 	noPointerWriter := newDataWriter(dm, usePointers)
-	_, err = noPointerWriter.maybeWrite(key)
+	_, err = noPointerWriter.maybeWrite(dm.get(idx))
 	require.NoError(t, err)
 
 	assert.Less(t, pointerWriter.Len(), noPointerWriter.Len())
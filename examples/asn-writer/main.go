@@ -76,12 +76,7 @@ func main() {
 		}
 	}
 
-	fh, err := os.Create("out.mmdb")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	_, err = writer.WriteTo(fh)
+	_, err = writer.WriteFile("out.mmdb", 0o644)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"log"
-	"os"
 
 	"github.com/maxmind/mmdbwriter"
 )
@@ -15,12 +14,7 @@ func main() {
 
 	// Insert your own data...
 
-	fh, err := os.Create("out.mmdb")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	_, err = writer.WriteTo(fh)
+	_, err = writer.WriteFile("out.mmdb", 0o644)
 	if err != nil {
 		log.Fatal(err)
 	}
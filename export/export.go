@@ -0,0 +1,311 @@
+// Package export writes the networks of a tree in the formats used to match
+// IP addresses at the edge: nginx geo blocks, HAProxy map files, and ipset
+// restore scripts.
+//
+// Either the whole value of each network or a single field of it, such as
+// country.iso_code, is exported. Adjacent networks with the same exported
+// value are collapsed into as few networks as possible. As the tree already
+// merges adjacent networks with equal values, this only needs to collapse
+// the networks whose values differ in other fields.
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/jsonl"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"go4.org/netipx"
+)
+
+// Options configures the exporters.
+type Options struct {
+	// Field is the dotted path of the value to export, e.g.,
+	// country.iso_code. Elements that are integers index arrays, e.g.,
+	// subdivisions.0.iso_code. Networks without a value at the path are
+	// skipped. If empty, the whole value of each network is exported.
+	Field string
+
+	// Network restricts the export to the networks within it. See
+	// mmdbwriter.Tree.NetworksWithin.
+	Network *net.IPNet
+
+	// Values restricts the export to the networks with one of the values,
+	// e.g., the countries to block.
+	Values []string
+
+	// Variable is the name of the variable set by the nginx geo block. It
+	// defaults to $geo.
+	Variable string
+
+	// Default is the value of the nginx variable for the addresses not in
+	// any network. If empty, the default is the empty string.
+	Default string
+
+	// SetName is the prefix of the names of the ipset sets. It defaults to
+	// geo.
+	SetName string
+}
+
+// Entry is an exported network and its value.
+type Entry struct {
+	Network netip.Prefix
+	Value   string
+}
+
+// Entries returns the networks in the tree with their exported values, in
+// order by network, collapsing adjacent networks with the same value.
+// Networks in the IPv4 subtree of an IPv6 tree are returned as IPv4
+// networks.
+func Entries(tree *mmdbwriter.Tree, opts Options) ([]Entry, error) {
+	var path []string
+	if opts.Field != "" {
+		path = strings.Split(opts.Field, ".")
+	}
+	var wanted map[string]bool
+	if opts.Values != nil {
+		wanted = map[string]bool{}
+		for _, v := range opts.Values {
+			wanted[v] = true
+		}
+	}
+
+	var entries []Entry
+	var current netipx.IPRange
+	var currentValue string
+	flush := func() {
+		if !current.IsValid() {
+			return
+		}
+		for _, prefix := range current.Prefixes() {
+			entries = append(entries, Entry{Network: prefix, Value: currentValue})
+		}
+		current = netipx.IPRange{}
+	}
+
+	fn := func(network *net.IPNet, value mmdbtype.DataType) error {
+		v, ok := lookup(value, path)
+		if !ok {
+			return nil
+		}
+		s, err := formatValue(v)
+		if err != nil {
+			return fmt.Errorf("formatting the value of %s: %w", network, err)
+		}
+		if wanted != nil && !wanted[s] {
+			return nil
+		}
+
+		prefix, ok := netipx.FromStdIPNet(network)
+		if !ok {
+			return fmt.Errorf("invalid network %s", network)
+		}
+		r := netipx.RangeOfPrefix(prefix)
+		if current.IsValid() && s == currentValue && current.To().Next() == r.From() {
+			current = netipx.IPRangeFrom(current.From(), r.To())
+			return nil
+		}
+		flush()
+		current = r
+		currentValue = s
+		return nil
+	}
+
+	var err error
+	if opts.Network != nil {
+		err = tree.NetworksWithin(opts.Network, fn)
+	} else {
+		err = tree.Networks(fn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	flush()
+	return entries, nil
+}
+
+// lookup returns the value at the path within v.
+func lookup(v mmdbtype.DataType, path []string) (mmdbtype.DataType, bool) {
+	for _, key := range path {
+		switch c := v.(type) {
+		case mmdbtype.Map:
+			var ok bool
+			if v, ok = c[mmdbtype.String(key)]; !ok {
+				return nil, false
+			}
+		case mmdbtype.Slice:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil, false
+			}
+			v = c[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, v != nil
+}
+
+// formatValue returns strings as they are and other values as JSON.
+func formatValue(v mmdbtype.DataType) (string, error) {
+	if s, ok := v.(mmdbtype.String); ok {
+		return string(s), nil
+	}
+	b, err := jsonl.MarshalValue(v, false)
+	return string(b), err
+}
+
+// WriteNginxGeo writes the networks in the tree as an nginx geo block,
+// which sets a variable to the value of the network containing the client
+// address:
+//
+//	geo $geo {
+//	    default "";
+//	    1.0.0.0/24 AU;
+//	}
+//
+// It returns the number of networks written.
+func WriteNginxGeo(w io.Writer, tree *mmdbwriter.Tree, opts Options) (int, error) {
+	entries, err := Entries(tree, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	variable := opts.Variable
+	if variable == "" {
+		variable = "$geo"
+	}
+	if !strings.HasPrefix(variable, "$") {
+		variable = "$" + variable
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "geo %s {\n    default %s;\n", variable, nginxQuote(opts.Default))
+	for _, e := range entries {
+		fmt.Fprintf(bw, "    %s %s;\n", e.Network, nginxQuote(e.Value))
+	}
+	fmt.Fprintf(bw, "}\n")
+	return len(entries), bw.Flush()
+}
+
+// nginxQuote quotes s if it is empty or contains characters that end or
+// change the meaning of a value in the nginx configuration.
+func nginxQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\r\n;{}\"'\\$#") {
+		return s
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// WriteHAProxyMap writes the networks in the tree as an HAProxy map file,
+// to be used with the map_ip converter:
+//
+//	1.0.0.0/24 AU
+//
+// It returns the number of networks written.
+func WriteHAProxyMap(w io.Writer, tree *mmdbwriter.Tree, opts Options) (int, error) {
+	entries, err := Entries(tree, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, e := range entries {
+		// The value is the rest of the line after the key and any
+		// whitespace, so only line breaks and leading whitespace cannot
+		// be represented.
+		if e.Value == "" || strings.ContainsAny(e.Value, "\r\n") || strings.TrimLeft(e.Value, " \t") != e.Value {
+			return 0, fmt.Errorf("the value %q of %s cannot be written to an HAProxy map", e.Value, e.Network)
+		}
+		fmt.Fprintf(bw, "%s %s\n", e.Network, e.Value)
+	}
+	return len(entries), bw.Flush()
+}
+
+// ipsetMaxElem is the maximum number of networks in an ipset set unless a
+// set has more. As sets only grow as needed, it is generous so that it
+// rarely differs between exports, which would prevent replacing the sets.
+const ipsetMaxElem = 1 << 20
+
+// ipsetMaxNameLen is the maximum length of the name of an ipset set.
+const ipsetMaxNameLen = 31
+
+// ipsetTempSuffix is appended to the names of the sets that are filled
+// before being swapped with the existing sets.
+const ipsetTempSuffix = "-tmp"
+
+// WriteIPSet writes the networks in the tree as a script for ipset restore.
+// The networks are added to a set named after the SetName option, the
+// value, and the IP version, e.g., geo-AU-v4 and geo-AU-v6. If no field is
+// exported, the sets are named after the SetName option and the IP version
+// only, e.g., geo-v4.
+//
+// The sets are created if they do not exist. Otherwise, their networks are
+// replaced atomically by filling a temporary set and swapping it with the
+// existing one, so the networks are never missing while restoring.
+//
+// It returns the number of networks written.
+func WriteIPSet(w io.Writer, tree *mmdbwriter.Tree, opts Options) (int, error) {
+	entries, err := Entries(tree, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	prefix := opts.SetName
+	if prefix == "" {
+		prefix = "geo"
+	}
+	sets := map[string][]netip.Prefix{}
+	for _, e := range entries {
+		name := prefix
+		if opts.Field != "" {
+			name += "-" + e.Value
+		}
+		if e.Network.Addr().Is4() {
+			name += "-v4"
+		} else {
+			name += "-v6"
+		}
+		if len(name)+len(ipsetTempSuffix) > ipsetMaxNameLen || strings.ContainsAny(name, " \t\r\n\"'") {
+			return 0, fmt.Errorf("%q is not a valid ipset set name", name)
+		}
+		sets[name] = append(sets[name], e.Network)
+	}
+
+	names := make([]string, 0, len(sets))
+	for name := range sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	for _, name := range names {
+		networks := sets[name]
+		family := "inet"
+		if strings.HasSuffix(name, "-v6") {
+			family = "inet6"
+		}
+		maxElem := ipsetMaxElem
+		if len(networks) > maxElem {
+			maxElem = len(networks)
+		}
+		tmp := name + ipsetTempSuffix
+		for _, set := range []string{name, tmp} {
+			fmt.Fprintf(bw, "create %s hash:net family %s maxelem %d -exist\n", set, family, maxElem)
+		}
+		fmt.Fprintf(bw, "flush %s\n", tmp)
+		for _, network := range networks {
+			fmt.Fprintf(bw, "add %s %s\n", tmp, network)
+		}
+		fmt.Fprintf(bw, "swap %s %s\ndestroy %s\n", tmp, name, tmp)
+	}
+	return len(entries), bw.Flush()
+}
@@ -0,0 +1,172 @@
+package export
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testTree(t *testing.T) *mmdbwriter.Tree {
+	t.Helper()
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+
+	country := func(isoCode string) mmdbtype.Map {
+		return mmdbtype.Map{"iso_code": mmdbtype.String(isoCode)}
+	}
+	for _, n := range []struct {
+		network string
+		value   mmdbtype.Map
+	}{
+		{"1.0.0.0/25", mmdbtype.Map{"country": country("AU"), "city": mmdbtype.String("Sydney")}},
+		{"1.0.0.128/25", mmdbtype.Map{"country": country("AU"), "city": mmdbtype.String("Perth")}},
+		{"1.0.1.0/24", mmdbtype.Map{"country": country("AU"), "city": mmdbtype.String("Hobart")}},
+		{"1.0.2.0/24", mmdbtype.Map{"country": country("CN")}},
+		{"1.0.3.0/24", mmdbtype.Map{"city": mmdbtype.String("Nowhere")}},
+		{"2600::/16", mmdbtype.Map{"country": country("US")}},
+	} {
+		_, network, err := net.ParseCIDR(n.network)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(network, n.value))
+	}
+	return tree
+}
+
+func TestEntries(t *testing.T) {
+	tree := testTree(t)
+
+	entries, err := Entries(tree, Options{Field: "country.iso_code"})
+	require.NoError(t, err)
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Network.String()+" "+e.Value)
+	}
+	assert.Equal(t, []string{"1.0.0.0/23 AU", "1.0.2.0/24 CN", "2600::/16 US"}, got)
+
+	entries, err = Entries(tree, Options{Field: "country", Values: []string{`{"iso_code":"CN"}`}})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "1.0.2.0/24", entries[0].Network.String())
+
+	_, network, err := net.ParseCIDR("1.0.0.0/24")
+	require.NoError(t, err)
+	entries, err = Entries(tree, Options{Field: "city", Network: network})
+	require.NoError(t, err)
+	assert.Equal(t, []Entry{
+		{Network: entries[0].Network, Value: "Sydney"},
+		{Network: entries[1].Network, Value: "Perth"},
+	}, entries)
+	assert.Equal(t, "1.0.0.128/25", entries[1].Network.String())
+}
+
+func TestLookup(t *testing.T) {
+	value := mmdbtype.Map{
+		"subdivisions": mmdbtype.Slice{
+			mmdbtype.Map{"iso_code": mmdbtype.String("NSW")},
+		},
+	}
+	v, ok := lookup(value, []string{"subdivisions", "0", "iso_code"})
+	assert.True(t, ok)
+	assert.Equal(t, mmdbtype.String("NSW"), v)
+
+	for _, path := range [][]string{
+		{"subdivisions", "1", "iso_code"},
+		{"subdivisions", "x"},
+		{"country"},
+		{"subdivisions", "0", "iso_code", "more"},
+	} {
+		_, ok := lookup(value, path)
+		assert.False(t, ok, path)
+	}
+}
+
+func TestWriteNginxGeo(t *testing.T) {
+	var buf bytes.Buffer
+	n, err := WriteNginxGeo(&buf, testTree(t), Options{Field: "country.iso_code", Variable: "country", Default: "ZZ"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, `geo $country {
+    default ZZ;
+    1.0.0.0/23 AU;
+    1.0.2.0/24 CN;
+    2600::/16 US;
+}
+`, buf.String())
+
+	buf.Reset()
+	_, err = WriteNginxGeo(&buf, testTree(t), Options{Values: []string{`{"country":{"iso_code":"US"}}`}})
+	require.NoError(t, err)
+	assert.Equal(t, `geo $geo {
+    default "";
+    2600::/16 "{\"country\":{\"iso_code\":\"US\"}}";
+}
+`, buf.String())
+}
+
+func TestNginxQuote(t *testing.T) {
+	tests := map[string]string{
+		"AU":         "AU",
+		"":           `""`,
+		"New York":   `"New York"`,
+		`a;b`:        `"a;b"`,
+		`say "hi"\n`: `"say \"hi\"\\n"`,
+		"tab\there":  `"tab\there"`,
+	}
+	for s, expected := range tests {
+		assert.Equal(t, expected, nginxQuote(s), s)
+	}
+}
+
+func TestWriteHAProxyMap(t *testing.T) {
+	var buf bytes.Buffer
+	n, err := WriteHAProxyMap(&buf, testTree(t), Options{Field: "city"})
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, `1.0.0.0/25 Sydney
+1.0.0.128/25 Perth
+1.0.1.0/24 Hobart
+1.0.3.0/24 Nowhere
+`, buf.String())
+
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+	_, network, err := net.ParseCIDR("1.0.0.0/24")
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(network, mmdbtype.String("two\nlines")))
+	_, err = WriteHAProxyMap(&buf, tree, Options{})
+	assert.EqualError(t, err, `the value "two\nlines" of 1.0.0.0/24 cannot be written to an HAProxy map`)
+}
+
+func TestWriteIPSet(t *testing.T) {
+	var buf bytes.Buffer
+	n, err := WriteIPSet(&buf, testTree(t), Options{Field: "country.iso_code", Values: []string{"AU", "US"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, `create geo-AU-v4 hash:net family inet maxelem 1048576 -exist
+create geo-AU-v4-tmp hash:net family inet maxelem 1048576 -exist
+flush geo-AU-v4-tmp
+add geo-AU-v4-tmp 1.0.0.0/23
+swap geo-AU-v4-tmp geo-AU-v4
+destroy geo-AU-v4-tmp
+create geo-US-v6 hash:net family inet6 maxelem 1048576 -exist
+create geo-US-v6-tmp hash:net family inet6 maxelem 1048576 -exist
+flush geo-US-v6-tmp
+add geo-US-v6-tmp 2600::/16
+swap geo-US-v6-tmp geo-US-v6
+destroy geo-US-v6-tmp
+`, buf.String())
+
+	buf.Reset()
+	_, err = WriteIPSet(&buf, testTree(t), Options{SetName: "blocked"})
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "add blocked-v4-tmp 1.0.0.0/25\n")
+	assert.Contains(t, buf.String(), "add blocked-v6-tmp 2600::/16\n")
+
+	_, err = WriteIPSet(&buf, testTree(t), Options{Field: "city", SetName: "edge-geo-blocking"})
+	assert.EqualError(t, err, `"edge-geo-blocking-Nowhere-v4" is not a valid ipset set name`)
+}
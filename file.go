@@ -0,0 +1,129 @@
+package mmdbwriter
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// WriteFileOption configures the behavior of WriteFile.
+type WriteFileOption func(*writeFileOptions)
+
+type writeFileOptions struct {
+	checksumFile bool
+}
+
+// WithChecksumFile causes WriteFile to also write a file containing the
+// SHA-256 digest of the database. The file is named after the database with
+// ".sha256" appended and is in the format used by the sha256sum utility.
+func WithChecksumFile() WriteFileOption {
+	return func(o *writeFileOptions) {
+		o.checksumFile = true
+	}
+}
+
+// WriteFile writes the tree to the file at path, returning the SHA-256
+// digest of the database.
+//
+// The database is first written to a temporary file in the same directory,
+// which is synced to disk and then renamed to path. As such, readers never
+// see a partially written database, even if they have the file memory
+// mapped. If a file already exists at path, it is replaced.
+func (t *Tree) WriteFile(
+	path string,
+	perm os.FileMode,
+	opts ...WriteFileOption,
+) ([]byte, error) {
+	var o writeFileOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	h := sha256.New()
+	err := writeFileAtomically(path, perm, func(w io.Writer) error {
+		_, err := t.WriteTo(io.MultiWriter(w, h))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	digest := h.Sum(nil)
+
+	if o.checksumFile {
+		err := writeFileAtomically(path+".sha256", perm, func(w io.Writer) error {
+			_, err := fmt.Fprintf(w, "%x  %s\n", digest, filepath.Base(path))
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return digest, nil
+}
+
+func writeFileAtomically(
+	path string,
+	perm os.FileMode,
+	write func(io.Writer) error,
+) (err error) {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := os.CreateTemp(dir, "."+name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	if err := write(f); err != nil {
+		return err
+	}
+
+	if err := f.Chmod(perm); err != nil {
+		return fmt.Errorf("setting permissions on %s: %w", f.Name(), err)
+	}
+
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("syncing %s: %w", f.Name(), err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", f.Name(), err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("renaming %s to %s: %w", f.Name(), path, err)
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir syncs the directory so that a rename within it is durable.
+func syncDir(dir string) error {
+	// Directories cannot be synced on Windows.
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir) //nolint:gosec // the directory we just wrote to
+	if err != nil {
+		return fmt.Errorf("opening directory %s: %w", dir, err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("syncing directory %s: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFile(t *testing.T) {
+	tree, err := New(
+		Options{
+			BuildEpoch:   1,
+			DatabaseType: "mmdbwriter-test",
+			Description:  map[string]string{"en": "Test database"},
+		},
+	)
+	require.NoError(t, err)
+
+	_, network, err := net.ParseCIDR("1.1.1.0/24")
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(network, mmdbtype.String("value")))
+
+	expected := &bytes.Buffer{}
+	_, err = tree.WriteTo(expected)
+	require.NoError(t, err)
+	expectedDigest := sha256.Sum256(expected.Bytes())
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.mmdb")
+
+	// An existing file is replaced.
+	require.NoError(t, os.WriteFile(path, []byte("old"), 0o600))
+
+	digest, err := tree.WriteFile(path, 0o644, WithChecksumFile())
+	require.NoError(t, err)
+	assert.Equal(t, expectedDigest[:], digest)
+
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, expected.Bytes(), contents)
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o644), info.Mode().Perm())
+
+	checksum, err := os.ReadFile(path + ".sha256")
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x  test.mmdb\n", expectedDigest), string(checksum))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "no temporary files are left behind")
+
+	_, err = tree.WriteFile(filepath.Join(dir, "missing", "test.mmdb"), 0o644)
+	assert.Error(t, err)
+}
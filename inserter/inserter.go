@@ -85,48 +85,63 @@ func deepMerge(existingValue, newValue mmdbtype.DataType) (mmdbtype.DataType, er
 	if newValue == nil {
 		return existingValue, nil
 	}
-	switch existingValue := existingValue.(type) {
-	case mmdbtype.Map:
-		newMap, ok := newValue.(mmdbtype.Map)
-		if !ok {
-			return newValue, nil
-		}
-		existingMap := existingValue.Copy().(mmdbtype.Map)
-		for k, v := range newMap {
-			nv, err := deepMerge(existingMap[k], v)
-			if err != nil {
-				return nil, err
+	// The new value is rebuilt bottom-up, so each Map or Slice in it is
+	// merged with the existing value at the same path after the values in
+	// it have been merged. The values that are not merged are shared with
+	// the existing and new values rather than copied.
+	return mmdbtype.Transform(
+		newValue,
+		func(path []mmdbtype.PathElem, v mmdbtype.DataType) (mmdbtype.DataType, error) {
+			existing := valueAt(existingValue, path)
+			switch v := v.(type) {
+			case nil:
+				return existing, nil
+			case mmdbtype.Map:
+				existingMap, ok := existing.(mmdbtype.Map)
+				if !ok {
+					return v, nil
+				}
+				merged := make(mmdbtype.Map, len(existingMap)+len(v))
+				for k, e := range existingMap {
+					merged[k] = e
+				}
+				for k, e := range v {
+					merged[k] = e
+				}
+				return merged, nil
+			case mmdbtype.Slice:
+				existingSlice, ok := existing.(mmdbtype.Slice)
+				if !ok || len(existingSlice) <= len(v) {
+					return v, nil
+				}
+				merged := make(mmdbtype.Slice, len(existingSlice))
+				copy(merged, existingSlice)
+				copy(merged, v)
+				return merged, nil
+			default:
+				return v, nil
 			}
-			existingMap[k] = nv
-		}
-		return existingMap, nil
-	case mmdbtype.Slice:
-		newSlice, ok := newValue.(mmdbtype.Slice)
-		if !ok {
-			return newValue, nil
-		}
-		length := len(existingValue)
-		if len(newSlice) > length {
-			length = len(newSlice)
-		}
+		},
+	)
+}
 
-		rv := make(mmdbtype.Slice, length)
-		for i := range rv {
-			var ev, nv mmdbtype.DataType
-			if i < len(existingValue) {
-				ev = existingValue[i]
-			}
-			if i < len(newSlice) {
-				nv = newSlice[i]
+// valueAt returns the value at the path in v, or nil if there is none.
+func valueAt(v mmdbtype.DataType, path []mmdbtype.PathElem) mmdbtype.DataType {
+	for _, e := range path {
+		switch c := v.(type) {
+		case mmdbtype.Map:
+			if e.IsIndex() {
+				return nil
 			}
-			var err error
-			rv[i], err = deepMerge(ev, nv)
-			if err != nil {
-				return nil, err
+			v = c[e.Key]
+		case mmdbtype.Slice:
+			if !e.IsIndex() || e.Index >= len(c) {
+				return nil
 			}
+			v = c[e.Index]
+		default:
+			return nil
 		}
-		return rv, nil
-	default:
-		return newValue, nil
 	}
+	return v
 }
@@ -180,3 +180,29 @@ func TestDeepMergeWith(t *testing.T) {
 		}
 	}
 }
+
+func TestDeepMergeWithDoesNotModifyValues(t *testing.T) {
+	existing := mmdbtype.Map{
+		"a": mmdbtype.Map{"b": mmdbtype.String("existing")},
+		"c": mmdbtype.Slice{mmdbtype.Uint32(1), mmdbtype.Uint32(2)},
+	}
+	existingCopy := existing.Copy()
+	newValue := mmdbtype.Map{
+		"a": mmdbtype.Map{"d": mmdbtype.String("new")},
+		"c": mmdbtype.Slice{mmdbtype.Uint32(3)},
+	}
+	newCopy := newValue.Copy()
+
+	v, err := DeepMergeWith(newValue)(existing)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"a": mmdbtype.Map{"b": mmdbtype.String("existing"), "d": mmdbtype.String("new")},
+			"c": mmdbtype.Slice{mmdbtype.Uint32(3), mmdbtype.Uint32(2)},
+		},
+		v,
+	)
+	assert.Equal(t, existingCopy, existing)
+	assert.Equal(t, newCopy, newValue)
+}
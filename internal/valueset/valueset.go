@@ -0,0 +1,69 @@
+// Package valueset deduplicates mmdbtype values.
+package valueset
+
+import (
+	"hash/maphash"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// Set assigns an index to each distinct value added to it. Values are
+// found by a hash of their serialized form, which makes it cheap to
+// deduplicate large numbers of values, e.g., the locations of the networks
+// in a city database. Values must not be modified once added.
+//
+// A Set is not safe for concurrent use.
+type Set struct {
+	values []mmdbtype.DataType
+	// indexes holds the indexes of the values by their hash. Different
+	// values may have the same hash.
+	indexes map[uint64][]int
+	hasher  hasher
+}
+
+// New returns an empty Set.
+func New() *Set {
+	s := &Set{indexes: map[uint64][]int{}}
+	s.hasher.SetSeed(maphash.MakeSeed())
+	return s
+}
+
+// Add adds the value to the set if an equal value is not in it yet. It
+// returns the index of the value and whether it was added. Indexes are
+// assigned in the order the values are first added, starting at 0.
+func (s *Set) Add(v mmdbtype.DataType) (int, bool, error) {
+	s.hasher.Reset()
+	if _, err := v.WriteTo(&s.hasher); err != nil {
+		return 0, false, err
+	}
+	key := s.hasher.Sum64()
+	for _, idx := range s.indexes[key] {
+		if s.values[idx].Equal(v) {
+			return idx, false, nil
+		}
+	}
+
+	idx := len(s.values)
+	s.values = append(s.values, v)
+	s.indexes[key] = append(s.indexes[key], idx)
+	return idx, true, nil
+}
+
+// Get returns the value at the index.
+func (s *Set) Get(idx int) mmdbtype.DataType {
+	return s.values[idx]
+}
+
+// Len returns the number of distinct values in the set.
+func (s *Set) Len() int {
+	return len(s.values)
+}
+
+// hasher hashes the serialized form of a value. It never uses pointers.
+type hasher struct {
+	maphash.Hash
+}
+
+func (h *hasher) WriteOrWritePointer(t mmdbtype.DataType) (int64, error) {
+	return t.WriteTo(h)
+}
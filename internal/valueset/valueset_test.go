@@ -0,0 +1,41 @@
+package valueset
+
+import (
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSet(t *testing.T) {
+	s := New()
+
+	values := []mmdbtype.DataType{
+		mmdbtype.Map{"city": mmdbtype.String("Sydney")},
+		mmdbtype.Map{"city": mmdbtype.String("Perth")},
+		mmdbtype.Map{"city": mmdbtype.String("Sydney")},
+		mmdbtype.String("Sydney"),
+		mmdbtype.Map{"city": mmdbtype.String("Perth")},
+	}
+	expected := []struct {
+		idx   int
+		added bool
+	}{
+		{0, true},
+		{1, true},
+		{0, false},
+		{2, true},
+		{1, false},
+	}
+	for i, v := range values {
+		idx, added, err := s.Add(v)
+		require.NoError(t, err)
+		assert.Equal(t, expected[i].idx, idx, "index of %v", v)
+		assert.Equal(t, expected[i].added, added, "whether %v was added", v)
+	}
+
+	assert.Equal(t, 3, s.Len())
+	assert.Equal(t, mmdbtype.Map{"city": mmdbtype.String("Perth")}, s.Get(1))
+	assert.Equal(t, mmdbtype.String("Sydney"), s.Get(2))
+}
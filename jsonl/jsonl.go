@@ -0,0 +1,237 @@
+// Package jsonl writes and reads networks and their values as JSON Lines,
+// i.e., one JSON object per line, also known as NDJSON.
+//
+// Each line has a "network" member containing the network in CIDR notation
+// and a "data" member containing the value:
+//
+//	{"network":"1.0.0.0/24","data":{"asn":13335}}
+//
+// When reading, a line may instead have "start" and "end" members
+// containing the first and last IP addresses of a range:
+//
+//	{"start":"1.0.0.1","end":"1.0.0.9","data":{"asn":13335}}
+//
+// Values may be written as plain JSON, which is easy to consume but loses
+// the type of each value, or as typed JSON, in which each value is an
+// object with a single member named after its type as returned by
+// mmdbtype.TypeName:
+//
+//	{"network":"1.0.0.0/24","data":{"map":{"asn":{"uint32":13335}}}}
+//
+// In typed JSON, maps contain typed values and arrays are JSON arrays of
+// typed values. Bytes are base64 encoded. The remaining values are JSON
+// numbers, strings, or booleans, except that non-finite floats are written
+// as strings such as "NaN" or "+Inf" as JSON does not support them.
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/netip"
+	"sort"
+	"strconv"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// Writer writes networks and their values as JSON Lines.
+type Writer struct {
+	// Typed causes the values to be written as typed JSON.
+	Typed bool
+
+	w   *bufio.Writer
+	buf bytes.Buffer
+}
+
+// NewWriter returns a Writer that writes to w. The output is buffered, so
+// Flush must be called once done.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Write writes a line for the network and its value.
+func (w *Writer) Write(network *net.IPNet, value mmdbtype.DataType) error {
+	w.buf.Reset()
+	w.buf.WriteString(`{"network":"`)
+	w.buf.WriteString(formatNetwork(network))
+	w.buf.WriteString(`","data":`)
+	if err := appendValue(&w.buf, value, w.Typed); err != nil {
+		return fmt.Errorf("encoding the value of %s: %w", network, err)
+	}
+	w.buf.WriteString("}\n")
+
+	_, err := w.w.Write(w.buf.Bytes())
+	return err
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// formatNetwork formats the network in CIDR notation. Unlike
+// net.IPNet.String, it does not format IPv4-mapped IPv6 networks, such as
+// those aliased to the IPv4 subtree, as IPv4 networks.
+func formatNetwork(network *net.IPNet) string {
+	addr, ok := netip.AddrFromSlice(network.IP)
+	ones, bits := network.Mask.Size()
+	if !ok || bits != addr.BitLen() {
+		return network.String()
+	}
+	return netip.PrefixFrom(addr, ones).String()
+}
+
+// DumpOptions configures Dump.
+type DumpOptions struct {
+	// Network restricts the dump to the networks within it. See
+	// mmdbwriter.Tree.NetworksWithin.
+	Network *net.IPNet
+
+	// IncludeAliasedNetworks also dumps the IPv6 networks aliased to the
+	// IPv4 subtree.
+	IncludeAliasedNetworks bool
+
+	// Typed writes the values as typed JSON.
+	Typed bool
+}
+
+// Dump writes each network in the tree that has a value to w as JSON Lines.
+// It returns the number of networks written.
+func Dump(w io.Writer, tree *mmdbwriter.Tree, opts DumpOptions) (int, error) {
+	jw := NewWriter(w)
+	jw.Typed = opts.Typed
+
+	var networksOpts []mmdbwriter.NetworksOption
+	if opts.IncludeAliasedNetworks {
+		networksOpts = append(networksOpts, mmdbwriter.IncludeAliasedNetworks())
+	}
+
+	count := 0
+	write := func(network *net.IPNet, value mmdbtype.DataType) error {
+		count++
+		return jw.Write(network, value)
+	}
+
+	var err error
+	if opts.Network != nil {
+		err = tree.NetworksWithin(opts.Network, write, networksOpts...)
+	} else {
+		err = tree.Networks(write, networksOpts...)
+	}
+	if err != nil {
+		return count, err
+	}
+	return count, jw.Flush()
+}
+
+// MarshalValue returns the JSON encoding of the value, which is typed JSON
+// if typed is set.
+func MarshalValue(v mmdbtype.DataType, typed bool) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := appendValue(&buf, v, typed); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func appendValue(buf *bytes.Buffer, v mmdbtype.DataType, typed bool) error {
+	if typed {
+		name := mmdbtype.TypeName(v)
+		if name == "" || name == mmdbtype.TypeNamePointer {
+			return fmt.Errorf("unsupported type %T", v)
+		}
+		buf.WriteString(`{"`)
+		buf.WriteString(name)
+		buf.WriteString(`":`)
+		defer buf.WriteByte('}')
+	}
+
+	switch v := v.(type) {
+	case mmdbtype.Map:
+		buf.WriteByte('{')
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, string(k))
+		}
+		sort.Strings(keys)
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			appendString(buf, k)
+			buf.WriteByte(':')
+			if err := appendValue(buf, v[mmdbtype.String(k)], typed); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case mmdbtype.Slice:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := appendValue(buf, e, typed); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case mmdbtype.String:
+		appendString(buf, string(v))
+	case mmdbtype.Bytes:
+		buf.WriteByte('"')
+		buf.WriteString(base64.StdEncoding.EncodeToString(v))
+		buf.WriteByte('"')
+	case mmdbtype.Bool:
+		buf.WriteString(strconv.FormatBool(bool(v)))
+	case mmdbtype.Float32:
+		return appendFloat(buf, float64(v), 32, typed)
+	case mmdbtype.Float64:
+		return appendFloat(buf, float64(v), 64, typed)
+	case mmdbtype.Int32:
+		buf.WriteString(strconv.FormatInt(int64(v), 10))
+	case mmdbtype.Uint16:
+		buf.WriteString(strconv.FormatUint(uint64(v), 10))
+	case mmdbtype.Uint32:
+		buf.WriteString(strconv.FormatUint(uint64(v), 10))
+	case mmdbtype.Uint64:
+		buf.WriteString(strconv.FormatUint(uint64(v), 10))
+	case *mmdbtype.Uint128:
+		buf.WriteString(v.String())
+	case nil:
+		buf.WriteString("null")
+	default:
+		return fmt.Errorf("unsupported type %T", v)
+	}
+	return nil
+}
+
+func appendFloat(buf *bytes.Buffer, f float64, bitSize int, typed bool) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		if !typed {
+			return fmt.Errorf("unsupported value %v", f)
+		}
+		buf.WriteByte('"')
+		buf.WriteString(strconv.FormatFloat(f, 'g', -1, bitSize))
+		buf.WriteByte('"')
+		return nil
+	}
+	buf.WriteString(strconv.FormatFloat(f, 'g', -1, bitSize))
+	return nil
+}
+
+func appendString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	// Encoding a string cannot fail.
+	_ = enc.Encode(s)
+	// Encode adds a newline.
+	buf.Truncate(buf.Len() - 1)
+}
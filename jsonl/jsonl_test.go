@@ -0,0 +1,98 @@
+package jsonl
+
+import (
+	"bytes"
+	"math"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalValue(t *testing.T) {
+	value := mmdbtype.Map{
+		"string":  mmdbtype.String("<a \"b\">"),
+		"array":   mmdbtype.Slice{mmdbtype.Uint16(1), mmdbtype.Bool(true)},
+		"bytes":   mmdbtype.Bytes{1, 2},
+		"double":  mmdbtype.Float64(1.5),
+		"float":   mmdbtype.Float32(0.1),
+		"int32":   mmdbtype.Int32(-1),
+		"uint32":  mmdbtype.Uint32(2),
+		"uint64":  mmdbtype.Uint64(math.MaxUint64),
+		"uint128": mmdbtype.NewUint128(1, 0),
+	}
+
+	plain, err := MarshalValue(value, false)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		`{"array":[1,true],"bytes":"AQI=","double":1.5,"float":0.1,"int32":-1,"string":"<a \"b\">",`+
+			`"uint128":18446744073709551616,"uint32":2,"uint64":18446744073709551615}`,
+		string(plain),
+	)
+
+	typed, err := MarshalValue(value, true)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		`{"map":{"array":{"array":[{"uint16":1},{"bool":true}]},"bytes":{"bytes":"AQI="},"double":{"double":1.5},`+
+			`"float":{"float":0.1},"int32":{"int32":-1},"string":{"string":"<a \"b\">"},`+
+			`"uint128":{"uint128":18446744073709551616},"uint32":{"uint32":2},`+
+			`"uint64":{"uint64":18446744073709551615}}}`,
+		string(typed),
+	)
+
+	typed, err = MarshalValue(mmdbtype.Float64(math.Inf(-1)), true)
+	require.NoError(t, err)
+	assert.Equal(t, `{"double":"-Inf"}`, string(typed))
+
+	_, err = MarshalValue(mmdbtype.Float64(math.NaN()), false)
+	assert.Error(t, err, "plain JSON cannot represent NaN")
+}
+
+func TestDump(t *testing.T) {
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+
+	for network, value := range map[string]mmdbtype.DataType{
+		"1.1.1.0/24": mmdbtype.Map{"a": mmdbtype.Uint32(1)},
+		"2.2.2.0/24": mmdbtype.Map{"b": mmdbtype.String("x")},
+		"2003::/16":  mmdbtype.Map{"c": mmdbtype.Bool(false)},
+		"1.1.2.0/24": mmdbtype.Map{"a": mmdbtype.Uint32(2)},
+	} {
+		_, n, err := net.ParseCIDR(network)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(n, value))
+	}
+
+	var buf bytes.Buffer
+	count, err := Dump(&buf, tree, DumpOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	assert.Equal(
+		t,
+		`{"network":"1.1.1.0/24","data":{"a":1}}
+{"network":"1.1.2.0/24","data":{"a":2}}
+{"network":"2.2.2.0/24","data":{"b":"x"}}
+{"network":"2003::/16","data":{"c":false}}
+`,
+		buf.String(),
+	)
+
+	_, within, err := net.ParseCIDR("::ffff:1.1.0.0/112")
+	require.NoError(t, err)
+	buf.Reset()
+	count, err = Dump(&buf, tree, DumpOptions{Network: within, IncludeAliasedNetworks: true, Typed: true})
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(
+		t,
+		`{"network":"::ffff:1.1.1.0/120","data":{"map":{"a":{"uint32":1}}}}
+{"network":"::ffff:1.1.2.0/120","data":{"map":{"a":{"uint32":2}}}}
+`,
+		buf.String(),
+	)
+}
@@ -0,0 +1,423 @@
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// Schema sets the types of the values read from plain JSON. It is usually
+// loaded from a sidecar JSON file with LoadSchema, e.g.:
+//
+//	{
+//	  "types": {
+//	    "autonomous_system_number": "uint32",
+//	    "location.accuracy_radius": "uint16",
+//	    "subdivisions.geoname_id": "uint32"
+//	  }
+//	}
+type Schema struct {
+	// Types maps the dot-separated path of a value within the data to the
+	// name of its type as accepted by mmdbtype.ParseValue. Arrays do not
+	// add an element to the path, i.e., the values in an array have the
+	// path of the array.
+	Types map[string]string `json:"types"`
+}
+
+// LoadSchema reads a Schema from a JSON file and validates it.
+func LoadSchema(path string) (*Schema, error) {
+	b, err := os.ReadFile(path) //nolint:gosec // reading the schema is the point
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &Schema{}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(schema); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := schema.Validate(); err != nil {
+		return nil, fmt.Errorf("validating %s: %w", path, err)
+	}
+	return schema, nil
+}
+
+// Validate checks that the Schema only contains known types and replaces
+// their names with the names used by mmdbtype.TypeName.
+func (s *Schema) Validate() error {
+	for path, typ := range s.Types {
+		name, ok := mmdbtype.CanonicalTypeName(typ)
+		if !ok || name == mmdbtype.TypeNamePointer {
+			return fmt.Errorf("unknown type %q for %s", typ, path)
+		}
+		s.Types[path] = name
+	}
+	return nil
+}
+
+func (s *Schema) typeOf(path string) string {
+	if s == nil {
+		return ""
+	}
+	return s.Types[path]
+}
+
+// Record is a line read from a JSON Lines file.
+type Record struct {
+	// Network is set if the line has a "network" member.
+	Network *net.IPNet
+	// Start and End are set if the line has "start" and "end" members
+	// rather than a "network" member.
+	Start net.IP
+	End   net.IP
+	// Data is the value read from the "data" member.
+	Data mmdbtype.DataType
+}
+
+// Insert inserts the record into the tree using the inserter.Func returned
+// by the provided generator for the record's data.
+func (r *Record) Insert(tree *mmdbwriter.Tree, f inserter.FuncGenerator) error {
+	if r.Network != nil {
+		return tree.InsertFunc(r.Network, f(r.Data))
+	}
+	return tree.InsertRangeFunc(r.Start, r.End, f(r.Data))
+}
+
+func (r *Record) describe() string {
+	if r.Network != nil {
+		return formatNetwork(r.Network)
+	}
+	return r.Start.String() + "-" + r.End.String()
+}
+
+// Reader reads Records from JSON Lines. Blank lines are skipped.
+//
+// Values in plain JSON are converted as follows unless the Schema sets
+// their type: strings, booleans, objects, and arrays become the
+// corresponding types; integers become a Uint32 if they fit, an Int32 if
+// they are negative, and otherwise a Uint64 or Uint128; other numbers
+// become a Float64; and nulls are left out of objects and arrays.
+type Reader struct {
+	// Typed causes the values to be read as typed JSON as written by a
+	// Writer with Typed set.
+	Typed bool
+
+	// Schema sets the types of values read from plain JSON. It is not used
+	// when reading typed JSON.
+	Schema *Schema
+
+	r    *bufio.Reader
+	line int
+}
+
+// NewReader returns a Reader that reads from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// line is the format of each line.
+type line struct {
+	Network string          `json:"network"`
+	Start   string          `json:"start"`
+	End     string          `json:"end"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// Read returns the next record. It returns io.EOF when there are no more
+// records.
+func (r *Reader) Read() (*Record, error) {
+	for {
+		b, err := r.r.ReadBytes('\n')
+		if len(b) == 0 && err != nil {
+			return nil, err
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		r.line++
+
+		if len(bytes.TrimSpace(b)) == 0 {
+			continue
+		}
+		record, err := r.parse(b)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		return record, nil
+	}
+}
+
+func (r *Reader) parse(b []byte) (*Record, error) {
+	var l line
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&l); err != nil {
+		return nil, err
+	}
+
+	record := &Record{}
+	switch {
+	case l.Network != "" && (l.Start != "" || l.End != ""):
+		return nil, errors.New("network may not be set along with start and end")
+	case l.Network != "":
+		_, network, err := net.ParseCIDR(l.Network)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", l.Network)
+		}
+		record.Network = network
+	case l.Start != "" && l.End != "":
+		if record.Start = net.ParseIP(l.Start); record.Start == nil {
+			return nil, fmt.Errorf("invalid start IP address %q", l.Start)
+		}
+		if record.End = net.ParseIP(l.End); record.End == nil {
+			return nil, fmt.Errorf("invalid end IP address %q", l.End)
+		}
+	default:
+		return nil, errors.New("either network or both start and end must be set")
+	}
+
+	if l.Data == nil {
+		return nil, errors.New("data must be set")
+	}
+	v, err := decodeJSON(l.Data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing data: %w", err)
+	}
+	if v == nil {
+		// Null values nested in the data are skipped, but skipping the
+		// data itself would leave nothing to insert.
+		return nil, errors.New("data must not be null")
+	}
+	if r.Typed {
+		record.Data, err = fromTyped(v)
+	} else {
+		record.Data, err = fromPlain(nil, v, r.Schema)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func decodeJSON(b []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// fromTyped converts a value decoded from typed JSON.
+func fromTyped(v any) (mmdbtype.DataType, error) {
+	obj, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("expected an object with one member but got %s", describeJSON(v))
+	}
+	if len(obj) != 1 {
+		return nil, fmt.Errorf("expected an object with one member but got %d members", len(obj))
+	}
+	var typ string
+	for k, e := range obj {
+		typ, v = k, e
+	}
+
+	name, ok := mmdbtype.CanonicalTypeName(typ)
+	if !ok {
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+
+	switch name {
+	case mmdbtype.TypeNameMap:
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("expected an object for a map but got %s", describeJSON(v))
+		}
+		m := make(mmdbtype.Map, len(obj))
+		for k, e := range obj {
+			value, err := fromTyped(e)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			m[mmdbtype.String(k)] = value
+		}
+		return m, nil
+	case mmdbtype.TypeNameArray:
+		arr, ok := v.([]any)
+		if !ok {
+			return nil, fmt.Errorf("expected an array but got %s", describeJSON(v))
+		}
+		s := make(mmdbtype.Slice, len(arr))
+		for i, e := range arr {
+			value, err := fromTyped(e)
+			if err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+			s[i] = value
+		}
+		return s, nil
+	}
+
+	var s string
+	switch v := v.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		return nil, fmt.Errorf("expected a %s but got %s", name, describeJSON(v))
+	}
+	return mmdbtype.ParseValue(name, s)
+}
+
+// fromPlain converts a value decoded from plain JSON at the provided path.
+func fromPlain(path []string, v any, schema *Schema) (mmdbtype.DataType, error) {
+	return convertPlain(path, v, schema.typeOf(strings.Join(path, ".")), schema)
+}
+
+func convertPlain(path []string, v any, typ string, schema *Schema) (mmdbtype.DataType, error) {
+	var value mmdbtype.DataType
+	var err error
+	switch v := v.(type) {
+	case map[string]any:
+		if typ != "" && typ != mmdbtype.TypeNameMap {
+			err = fmt.Errorf("expected a %s but got an object", typ)
+			break
+		}
+		m := make(mmdbtype.Map, len(v))
+		for k, e := range v {
+			if e == nil {
+				continue
+			}
+			// Errors from nested values already include their path.
+			value, err := fromPlain(append(path, k), e, schema)
+			if err != nil {
+				return nil, err
+			}
+			m[mmdbtype.String(k)] = value
+		}
+		return m, nil
+	case []any:
+		// The values in an array have the path of the array, so the type
+		// applies to them unless it is the type of the array itself.
+		if typ == mmdbtype.TypeNameArray {
+			typ = ""
+		}
+		s := make(mmdbtype.Slice, 0, len(v))
+		for _, e := range v {
+			if e == nil {
+				continue
+			}
+			value, err := convertPlain(path, e, typ, schema)
+			if err != nil {
+				return nil, err
+			}
+			s = append(s, value)
+		}
+		return s, nil
+	case string:
+		if typ == "" {
+			return mmdbtype.String(v), nil
+		}
+		value, err = mmdbtype.ParseValue(typ, v)
+	case bool:
+		if typ == "" {
+			return mmdbtype.Bool(v), nil
+		}
+		value, err = mmdbtype.ParseValue(typ, strconv.FormatBool(v))
+	case json.Number:
+		if typ == "" {
+			value, err = fromNumber(v)
+		} else {
+			value, err = mmdbtype.ParseValue(typ, v.String())
+		}
+	default:
+		err = fmt.Errorf("unexpected value %v", v)
+	}
+
+	if err != nil && len(path) > 0 {
+		return nil, fmt.Errorf("%s: %w", strings.Join(path, "."), err)
+	}
+	return value, err
+}
+
+// fromNumber converts a number whose type is not set by the schema.
+func fromNumber(n json.Number) (mmdbtype.DataType, error) {
+	s := n.String()
+	if strings.ContainsAny(s, ".eE") {
+		f, err := n.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", s)
+		}
+		return mmdbtype.Float64(f), nil
+	}
+
+	if strings.HasPrefix(s, "-") {
+		i, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("integer %s is too small for an int32", s)
+		}
+		return mmdbtype.Int32(i), nil
+	}
+
+	u, err := strconv.ParseUint(s, 10, 64)
+	switch {
+	case err != nil:
+		return mmdbtype.ParseUint128(s)
+	case u <= math.MaxUint32:
+		return mmdbtype.Uint32(u), nil
+	default:
+		return mmdbtype.Uint64(u), nil
+	}
+}
+
+func describeJSON(v any) string {
+	switch v := v.(type) {
+	case map[string]any:
+		return "an object"
+	case []any:
+		return "an array"
+	case nil:
+		return "null"
+	case string:
+		return strconv.Quote(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Insert inserts each record read from r into the tree using the
+// inserter.Func returned by the provided generator for the record's data.
+// It returns the number of records inserted.
+func Insert(tree *mmdbwriter.Tree, r *Reader, f inserter.FuncGenerator) (int, error) {
+	count := 0
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if err := record.Insert(tree, f); err != nil {
+			return count, fmt.Errorf("line %d: inserting %s: %w", r.line, record.describe(), err)
+		}
+		count++
+	}
+}
@@ -0,0 +1,132 @@
+package jsonl
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderPlain(t *testing.T) {
+	input := `{"network":"1.0.0.0/24","data":{"asn":13335,"org":"Cloudflare","score":-1,"ratio":0.5,"big":5000000000}}
+
+{"start":"1.0.1.1","end":"1.0.1.9","data":{"location":{"radius":10,"lat":1},"tags":[1,null,2],"x":null}}
+`
+	r := NewReader(strings.NewReader(input))
+	r.Schema = &Schema{Types: map[string]string{
+		"location.radius": "uint16",
+		"location.lat":    "float64",
+		"tags":            "uint64",
+	}}
+	require.NoError(t, r.Schema.Validate())
+
+	record, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, "1.0.0.0/24", record.Network.String())
+	assert.Equal(t, mmdbtype.Map{
+		"asn":   mmdbtype.Uint32(13335),
+		"org":   mmdbtype.String("Cloudflare"),
+		"score": mmdbtype.Int32(-1),
+		"ratio": mmdbtype.Float64(0.5),
+		"big":   mmdbtype.Uint64(5000000000),
+	}, record.Data)
+
+	record, err = r.Read()
+	require.NoError(t, err)
+	assert.Nil(t, record.Network)
+	assert.Equal(t, net.ParseIP("1.0.1.1"), record.Start)
+	assert.Equal(t, net.ParseIP("1.0.1.9"), record.End)
+	assert.Equal(t, mmdbtype.Map{
+		"location": mmdbtype.Map{
+			"radius": mmdbtype.Uint16(10),
+			"lat":    mmdbtype.Float64(1),
+		},
+		"tags": mmdbtype.Slice{mmdbtype.Uint64(1), mmdbtype.Uint64(2)},
+	}, record.Data)
+
+	_, err = r.Read()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := map[string]string{
+		`{"data":{}}`: "line 1: either network or both start and end must be set",
+		`{"network":"1.0.0.0/24","start":"1.0.0.0","end":"1.0.0.1","data":{}}`: "line 1: network may not " +
+			"be set along with start and end",
+		`{"network":"1.0.0.0","data":{}}`:                     `line 1: invalid network "1.0.0.0"`,
+		`{"network":"1.0.0.0/24"}`:                            "line 1: data must be set",
+		`{"network":"1.0.0.0/24","data":null}`:                "line 1: data must not be null",
+		`{"network":"1.0.0.0/24","data":{"a":{"b":"x"}}}`:     `line 1: a.b: invalid uint16 "x"`,
+		`{"network":"1.0.0.0/24","data":{"a":{"c":{}}}}`:      "line 1: a.c: expected a bool but got an object",
+		`{"network":"1.0.0.0/24","data":{"a":-3000000000}}`:   "line 1: a: integer -3000000000 is too small for an int32",
+		`{"network":"1.0.0.0/24","data":{},"extra":true}`:     `line 1: json: unknown field "extra"`,
+		`{"network":"1.0.0.0/24","data":{"a":1e400}}`:         "line 1: a: invalid number 1e400",
+		`{"network":"1.0.0.0/24","data":{"a":{"b":[1,"y"]}}}`: `line 1: a.b: invalid uint16 "y"`,
+	}
+	for input, expected := range tests {
+		r := NewReader(strings.NewReader(input))
+		r.Schema = &Schema{Types: map[string]string{"a.b": "uint16", "a.c": "bool"}}
+		_, err := r.Read()
+		assert.EqualError(t, err, expected, input)
+	}
+
+	r := NewReader(strings.NewReader(`{"network":"1.0.0.0/24","data":{"uint32":1,"string":"x"}}`))
+	r.Typed = true
+	_, err := r.Read()
+	assert.EqualError(t, err, "line 1: expected an object with one member but got 2 members")
+
+	r = NewReader(strings.NewReader(`{"network":"1.0.0.0/24","data":null}`))
+	r.Typed = true
+	_, err = r.Read()
+	assert.EqualError(t, err, "line 1: data must not be null")
+}
+
+func TestRoundTrip(t *testing.T) {
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+
+	value := mmdbtype.Map{
+		"array":   mmdbtype.Slice{mmdbtype.Uint16(1), mmdbtype.Bool(true)},
+		"bytes":   mmdbtype.Bytes{1, 2},
+		"double":  mmdbtype.Float64(math.Inf(1)),
+		"float":   mmdbtype.Float32(0.1),
+		"int32":   mmdbtype.Int32(-1),
+		"map":     mmdbtype.Map{"string": mmdbtype.String("x")},
+		"uint32":  mmdbtype.Uint32(2),
+		"uint64":  mmdbtype.Uint64(3),
+		"uint128": mmdbtype.NewUint128(1, 0),
+	}
+	for _, network := range []string{"1.0.0.0/24", "2003::/16"} {
+		_, n, err := net.ParseCIDR(network)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(n, value))
+	}
+
+	var dumped bytes.Buffer
+	_, err = Dump(&dumped, tree, DumpOptions{Typed: true})
+	require.NoError(t, err)
+
+	loaded, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+	r := NewReader(bytes.NewReader(dumped.Bytes()))
+	r.Typed = true
+	count, err := Insert(loaded, r, inserter.ReplaceWith)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	_, got := loaded.Get(net.ParseIP("2003::1"))
+	assert.Equal(t, value, got)
+
+	var redumped bytes.Buffer
+	_, err = Dump(&redumped, loaded, DumpOptions{Typed: true})
+	require.NoError(t, err)
+	assert.Equal(t, dumped.String(), redumped.String())
+}
@@ -0,0 +1,136 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// reservedMetadataKeys are the metadata keys defined by the MaxMind DB spec.
+// They are always set by the writer and may not be set through
+// ExtraMetadata.
+var reservedMetadataKeys = map[mmdbtype.String]struct{}{
+	"binary_format_major_version": {},
+	"binary_format_minor_version": {},
+	"build_epoch":                 {},
+	"database_type":               {},
+	"description":                 {},
+	"ip_version":                  {},
+	"languages":                   {},
+	"node_count":                  {},
+	"record_size":                 {},
+}
+
+// maxMetadataSize is the maximum size of the metadata section, including
+// the start marker. Readers only search this much of the end of the file
+// for the marker.
+const maxMetadataSize = 128 * 1024
+
+func validateExtraMetadata(extra mmdbtype.Map) error {
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, string(k))
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if _, ok := reservedMetadataKeys[mmdbtype.String(k)]; ok {
+			return fmt.Errorf("ExtraMetadata may not contain the reserved key %q", k)
+		}
+		if extra[mmdbtype.String(k)] == nil {
+			return fmt.Errorf("ExtraMetadata contains a nil value for %q", k)
+		}
+	}
+	return nil
+}
+
+// readExtraMetadata returns the metadata entries in the database at path
+// that are not defined by the MaxMind DB spec. The reader only decodes the
+// entries in its Metadata struct, so the metadata section is read from the
+// end of the file and decoded again here.
+func readExtraMetadata(path string) (mmdbtype.Map, error) {
+	f, err := os.Open(path) //nolint:gosec // the path passed to Load
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	start := info.Size() - maxMetadataSize
+	if start < 0 {
+		start = 0
+	}
+	buf := make([]byte, info.Size()-start)
+	if _, err := f.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+
+	markerStart := bytes.LastIndex(buf, metadataStartMarker)
+	if markerStart == -1 {
+		return nil, errors.New("metadata start marker not found")
+	}
+
+	metadata, err := decodeMetadata(buf[markerStart+len(metadataStartMarker):])
+	if err != nil {
+		return nil, fmt.Errorf("decoding metadata: %w", err)
+	}
+
+	extra := mmdbtype.Map{}
+	for k, v := range metadata {
+		if _, ok := reservedMetadataKeys[k]; !ok {
+			extra[k] = v
+		}
+	}
+	return extra, nil
+}
+
+// decodeMetadata decodes the metadata section into an mmdbtype.Map.
+//
+// The reader does not expose a way to decode arbitrary data, so we wrap the
+// metadata section in a minimal database that has no search tree and whose
+// data section is the metadata section. Pointers in the metadata section are
+// relative to its start, which makes them valid in the data section of the
+// wrapping database.
+func decodeMetadata(section []byte) (mmdbtype.Map, error) {
+	dw := newDataWriter(newDataMap(), false)
+	_, err := mmdbtype.Map{
+		"ip_version":  mmdbtype.Uint16(4),
+		"node_count":  mmdbtype.Uint32(0),
+		"record_size": mmdbtype.Uint16(24),
+	}.WriteTo(dw)
+	if err != nil {
+		return nil, err
+	}
+
+	db := make([]byte, 0, len(dataSectionSeparator)+len(section)+len(metadataStartMarker)+dw.Len())
+	db = append(db, dataSectionSeparator...)
+	db = append(db, section...)
+	db = append(db, metadataStartMarker...)
+	db = append(db, dw.Bytes()...)
+
+	reader, err := maxminddb.FromBytes(db)
+	if err != nil {
+		return nil, err
+	}
+
+	dser := newDeserializer()
+	if err := reader.Decode(0, dser); err != nil {
+		return nil, err
+	}
+
+	metadata, ok := dser.rv.(mmdbtype.Map)
+	if !ok {
+		return nil, fmt.Errorf("expected metadata to be a map but it was a %T", dser.rv)
+	}
+	return metadata, nil
+}
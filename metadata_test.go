@@ -0,0 +1,91 @@
+package mmdbwriter
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtraMetadata(t *testing.T) {
+	_, err := New(
+		Options{
+			ExtraMetadata: mmdbtype.Map{
+				"source":     mmdbtype.String("feed"),
+				"node_count": mmdbtype.Uint32(1),
+			},
+		},
+	)
+	assert.EqualError(t, err, `ExtraMetadata may not contain the reserved key "node_count"`)
+
+	extra := mmdbtype.Map{
+		"build_sha": mmdbtype.String("0123456789abcdef"),
+		"sources": mmdbtype.Map{
+			// The repeated value is written as a pointer.
+			"feed-a": mmdbtype.String("2023-01-01 release"),
+			"feed-b": mmdbtype.String("2023-01-01 release"),
+		},
+	}
+	tree, err := New(
+		Options{
+			DatabaseType:  "mmdbwriter-test",
+			Description:   map[string]string{"en": "Test database"},
+			ExtraMetadata: extra,
+		},
+	)
+	require.NoError(t, err)
+
+	// Changes to the map after New are not validated, so they are ignored.
+	extra["node_count"] = mmdbtype.Uint32(1)
+
+	_, network, err := net.ParseCIDR("1.1.1.0/24")
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(network, mmdbtype.String("value")))
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	_, err = tree.WriteFile(path, 0o600)
+	require.NoError(t, err)
+	delete(extra, "node_count")
+
+	reader, err := maxminddb.Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, "mmdbwriter-test", reader.Metadata.DatabaseType)
+	require.NoError(t, reader.Verify())
+	require.NoError(t, reader.Close())
+
+	read, err := readExtraMetadata(path)
+	require.NoError(t, err)
+	assert.Equal(t, extra, read)
+
+	loaded, err := Load(
+		path,
+		Options{
+			ExtraMetadata: mmdbtype.Map{
+				"build_sha": mmdbtype.String("fedcba9876543210"),
+				"license":   mmdbtype.String("CC BY-SA 4.0"),
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	loadedPath := filepath.Join(t.TempDir(), "loaded.mmdb")
+	_, err = loaded.WriteFile(loadedPath, 0o600)
+	require.NoError(t, err)
+
+	read, err = readExtraMetadata(loadedPath)
+	require.NoError(t, err)
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"build_sha": mmdbtype.String("fedcba9876543210"),
+			"license":   mmdbtype.String("CC BY-SA 4.0"),
+			"sources":   extra["sources"],
+		},
+		read,
+		"unknown keys are preserved by Load unless overridden",
+	)
+}
@@ -0,0 +1,171 @@
+package mmdbtype
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The names of the types as used by TypeName and ParseValue. They match
+// the names used in the MaxMind DB spec, except for "string" and "bool",
+// which are shorter.
+const (
+	TypeNameArray   = "array"
+	TypeNameBool    = "bool"
+	TypeNameBytes   = "bytes"
+	TypeNameDouble  = "double"
+	TypeNameFloat   = "float"
+	TypeNameInt32   = "int32"
+	TypeNameMap     = "map"
+	TypeNamePointer = "pointer"
+	TypeNameString  = "string"
+	TypeNameUint16  = "uint16"
+	TypeNameUint32  = "uint32"
+	TypeNameUint64  = "uint64"
+	TypeNameUint128 = "uint128"
+)
+
+// typeNameAliases maps alternative names accepted by ParseValue to the
+// names above.
+var typeNameAliases = map[string]string{
+	"boolean":     TypeNameBool,
+	"float32":     TypeNameFloat,
+	"float64":     TypeNameDouble,
+	"slice":       TypeNameArray,
+	"utf8_string": TypeNameString,
+}
+
+// TypeName returns the name of the type of v, e.g., "uint32", or the empty
+// string if v is nil.
+func TypeName(v DataType) string {
+	switch v.(type) {
+	case Slice:
+		return TypeNameArray
+	case Bool:
+		return TypeNameBool
+	case Bytes:
+		return TypeNameBytes
+	case Float64:
+		return TypeNameDouble
+	case Float32:
+		return TypeNameFloat
+	case Int32:
+		return TypeNameInt32
+	case Map:
+		return TypeNameMap
+	case Pointer:
+		return TypeNamePointer
+	case String:
+		return TypeNameString
+	case Uint16:
+		return TypeNameUint16
+	case Uint32:
+		return TypeNameUint32
+	case Uint64:
+		return TypeNameUint64
+	case *Uint128:
+		return TypeNameUint128
+	default:
+		return ""
+	}
+}
+
+// CanonicalTypeName returns the name used by TypeName for a type name
+// accepted by ParseValue. The boolean is false if the name is unknown.
+func CanonicalTypeName(name string) (string, bool) {
+	name = strings.ToLower(name)
+	if alias, ok := typeNameAliases[name]; ok {
+		return alias, true
+	}
+	switch name {
+	case TypeNameArray, TypeNameBool, TypeNameBytes, TypeNameDouble,
+		TypeNameFloat, TypeNameInt32, TypeNameMap, TypeNameString,
+		TypeNameUint16, TypeNameUint32, TypeNameUint64, TypeNameUint128:
+		return name, true
+	default:
+		return "", false
+	}
+}
+
+// ParseValue parses s as a value of the named type. Integers may be
+// written in decimal or with a "0x", "0o", or "0b" base prefix. Unlike in
+// Go, a leading zero does not make an integer octal. Booleans are
+// parsed with strconv.ParseBool and bytes are base64 encoded. Maps and
+// arrays cannot be parsed from a string.
+func ParseValue(typeName, s string) (DataType, error) {
+	name, ok := CanonicalTypeName(typeName)
+	if !ok {
+		return nil, fmt.Errorf("unknown type %q", typeName)
+	}
+
+	switch name {
+	case TypeNameBool:
+		v, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool %q", s)
+		}
+		return Bool(v), nil
+	case TypeNameBytes:
+		v, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 bytes %q: %w", s, err)
+		}
+		return Bytes(v), nil
+	case TypeNameDouble:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid double %q", s)
+		}
+		return Float64(v), nil
+	case TypeNameFloat:
+		v, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid float %q", s)
+		}
+		return Float32(v), nil
+	case TypeNameInt32:
+		v, err := strconv.ParseInt(s, integerBase(s), 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid int32 %q", s)
+		}
+		return Int32(v), nil
+	case TypeNameString:
+		return String(s), nil
+	case TypeNameUint16:
+		v, err := strconv.ParseUint(s, integerBase(s), 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uint16 %q", s)
+		}
+		return Uint16(v), nil
+	case TypeNameUint32:
+		v, err := strconv.ParseUint(s, integerBase(s), 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uint32 %q", s)
+		}
+		return Uint32(v), nil
+	case TypeNameUint64:
+		v, err := strconv.ParseUint(s, integerBase(s), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uint64 %q", s)
+		}
+		return Uint64(v), nil
+	case TypeNameUint128:
+		return ParseUint128(s)
+	default:
+		return nil, fmt.Errorf("cannot parse a %s from a string", name)
+	}
+}
+
+// integerBase returns the base to pass to the strconv functions for s. It
+// is 0, i.e., determined by the prefix, only if s has a base prefix.
+func integerBase(s string) int {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	if len(s) > 2 && s[0] == '0' {
+		switch s[1] {
+		case 'x', 'X', 'o', 'O', 'b', 'B':
+			return 0
+		}
+	}
+	return 10
+}
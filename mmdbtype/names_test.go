@@ -0,0 +1,54 @@
+package mmdbtype
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		typeName string
+		s        string
+		expected DataType
+	}{
+		{"bool", "true", Bool(true)},
+		{"boolean", "0", Bool(false)},
+		{"bytes", "AQI=", Bytes{1, 2}},
+		{"double", "1.5", Float64(1.5)},
+		{"float", "-2.25", Float32(-2.25)},
+		{"int32", "-42", Int32(-42)},
+		{"string", " a b ", String(" a b ")},
+		{"utf8_string", "", String("")},
+		{"uint16", "0x10", Uint16(16)},
+		{"uint32", "010", Uint32(10)},
+		{"UINT64", "18446744073709551615", Uint64(18446744073709551615)},
+		{"uint128", "0b11", NewUint128(0, 3)},
+	}
+	for _, test := range tests {
+		t.Run(test.typeName+"/"+test.s, func(t *testing.T) {
+			v, err := ParseValue(test.typeName, test.s)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, v)
+
+			name, ok := CanonicalTypeName(test.typeName)
+			require.True(t, ok)
+			assert.Equal(t, name, TypeName(v))
+		})
+	}
+
+	for _, test := range []struct{ typeName, s string }{
+		{"uint16", "65536"},
+		{"uint32", "-1"},
+		{"int32", "1.0"},
+		{"bool", "yes"},
+		{"bytes", "!"},
+		{"map", "{}"},
+		{"array", "[]"},
+		{"uuid", "x"},
+	} {
+		_, err := ParseValue(test.typeName, test.s)
+		assert.Error(t, err, "%s %q", test.typeName, test.s)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"math/big"
 	"math/bits"
+	"net/netip"
 	"reflect"
 	"sort"
 )
@@ -291,14 +292,21 @@ func (t Map) WriteTo(w writer) (int64, error) {
 	}
 
 	// We want database builds to be reproducible. As such, we insert
-	// the map items in order by key value. In the future, we will
-	// likely use a more relevant characteristic here (e.g., putting
-	// fields more likely to be accessed first).
+	// the map items in order by key value, unless the writer provides a
+	// KeyOrder, in which case keys that it orders the same remain in
+	// order by key value.
 	keys := make([]string, 0, len(t))
 	for k := range t {
 		keys = append(keys, string(k))
 	}
 	sort.Strings(keys)
+	if ko, ok := w.(keyOrderer); ok {
+		if order := ko.KeyOrder(); order != nil {
+			sort.SliceStable(keys, func(i, j int) bool {
+				return order(String(keys[i]), String(keys[j]))
+			})
+		}
+	}
 
 	for _, ks := range keys {
 		k := String(ks)
@@ -316,6 +324,41 @@ func (t Map) WriteTo(w writer) (int64, error) {
 	return numBytes, nil
 }
 
+// KeyOrder determines the order in which the keys of a Map are written. It
+// reports whether key a should be written before key b. Keys that it orders
+// the same, i.e., where neither is before the other, are written in order
+// by key value. Writing the keys most likely to be accessed first allows
+// readers that only decode part of a map to stop earlier.
+//
+// A KeyOrder must be a strict weak ordering and must not depend on anything
+// other than its arguments so that database builds remain reproducible.
+type KeyOrder func(a, b String) bool
+
+// PriorityKeyOrder returns a KeyOrder that writes the provided keys first,
+// in the order provided, followed by all other keys in order by key value.
+func PriorityKeyOrder(keys ...String) KeyOrder {
+	priorities := make(map[String]int, len(keys))
+	for i, k := range keys {
+		if _, ok := priorities[k]; !ok {
+			priorities[k] = i
+		}
+	}
+	return func(a, b String) bool {
+		pa, aOK := priorities[a]
+		pb, bOK := priorities[b]
+		if aOK && bOK {
+			return pa < pb
+		}
+		return aOK && !bOK
+	}
+}
+
+// keyOrderer is implemented by writers that write Map keys in an order
+// other than by key value.
+type keyOrderer interface {
+	KeyOrder() KeyOrder
+}
+
 // Pointer is the MaxMind DB pointer type for internal use in the writer. You
 // should not use this type in data structures that you pass to methods on
 // mmdbwriter.Tree. Doing so may result in a corrupt database.
@@ -652,10 +695,146 @@ func (t Uint64) WriteTo(w writer) (int64, error) {
 }
 
 // Uint128 is the MaxMind DB unsigned 128-bit integer type.
+//
+// It is a big.Int so that any integer may be converted to it. However,
+// only values from 0 to 2^128-1 may be written. NewUint128,
+// Uint128FromAddr, and ParseUint128 may be used to construct one without
+// using big.Int directly. The values they return keep their digits in a
+// fixed-size array allocated along with the big.Int.
 type Uint128 big.Int
 
 var _ DataType = (*Uint128)(nil)
 
+// uint128Words is the number of big.Words in 128 bits.
+const uint128Words = 128 / bits.UintSize
+
+// uint128Storage is a big.Int along with the array that holds its digits.
+type uint128Storage struct {
+	v     big.Int
+	words [uint128Words]big.Word
+}
+
+// NewUint128 returns the Uint128 whose upper 64 bits are hi and whose
+// lower 64 bits are lo.
+func NewUint128(hi, lo uint64) *Uint128 {
+	s := &uint128Storage{}
+	const halfWords = uint128Words / 2
+	for i := 0; i < halfWords; i++ {
+		s.words[i] = big.Word(lo >> (i * bits.UintSize))
+		s.words[halfWords+i] = big.Word(hi >> (i * bits.UintSize))
+	}
+	// SetBits uses the array rather than copying it.
+	s.v.SetBits(s.words[:])
+	return (*Uint128)(&s.v)
+}
+
+// Uint128FromAddr returns the Uint128 whose value is the address
+// interpreted as a big-endian integer, e.g., 2001:db8::1 becomes
+// 0x20010db8000000000000000000000001. IPv4 addresses are 32-bit values.
+func Uint128FromAddr(addr netip.Addr) *Uint128 {
+	if addr.Is4() {
+		b := addr.As4()
+		return NewUint128(0, uint64(binary.BigEndian.Uint32(b[:])))
+	}
+	b := addr.As16()
+	return NewUint128(binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:]))
+}
+
+// ParseUint128 parses a Uint128 from a decimal string or from a string
+// with a "0x", "0o", or "0b" base prefix. Underscores may separate the
+// digits after a base prefix.
+func ParseUint128(s string) (*Uint128, error) {
+	digits := s
+	base := uint64(10)
+	if len(s) > 2 && s[0] == '0' {
+		switch s[1] {
+		case 'x', 'X':
+			base = 16
+		case 'o', 'O':
+			base = 8
+		case 'b', 'B':
+			base = 2
+		}
+		if base != 10 {
+			digits = s[2:]
+		}
+	}
+
+	var hi, lo uint64
+	seen := false
+	for i := 0; i < len(digits); i++ {
+		c := digits[i]
+		if c == '_' && base != 10 {
+			continue
+		}
+		d, ok := digitValue(c)
+		if !ok || d >= base {
+			return nil, fmt.Errorf("invalid uint128: %q", s)
+		}
+		seen = true
+
+		// hi, lo = hi, lo*base + d
+		overflow, newHi := bits.Mul64(hi, base)
+		carry, newLo := bits.Mul64(lo, base)
+		newHi, c1 := bits.Add64(newHi, carry, 0)
+		newLo, c2 := bits.Add64(newLo, d, 0)
+		newHi, c3 := bits.Add64(newHi, 0, c2)
+		if overflow != 0 || c1 != 0 || c3 != 0 {
+			return nil, fmt.Errorf("uint128 out of range: %s", s)
+		}
+		hi, lo = newHi, newLo
+	}
+	if !seen {
+		return nil, fmt.Errorf("invalid uint128: %q", s)
+	}
+	return NewUint128(hi, lo), nil
+}
+
+func digitValue(c byte) (uint64, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return uint64(c - '0'), true
+	case 'a' <= c && c <= 'f':
+		return uint64(c-'a') + 10, true
+	case 'A' <= c && c <= 'F':
+		return uint64(c-'A') + 10, true
+	default:
+		return 0, false
+	}
+}
+
+// Uint64s returns the upper and lower 64 bits of the value. Values that
+// are out of range are truncated.
+func (t *Uint128) Uint64s() (hi, lo uint64) {
+	v := (*big.Int)(t)
+	if v.Sign() < 0 || v.BitLen() > 128 {
+		v = new(big.Int).And(v, maxUint128)
+	}
+	const halfWords = uint128Words / 2
+	for i, w := range v.Bits() {
+		if i < halfWords {
+			lo |= uint64(w) << (i * bits.UintSize)
+		} else {
+			hi |= uint64(w) << ((i - halfWords) * bits.UintSize)
+		}
+	}
+	return hi, lo
+}
+
+// Addr returns the value as an IPv6 address.
+func (t *Uint128) Addr() netip.Addr {
+	hi, lo := t.Uint64s()
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], hi)
+	binary.BigEndian.PutUint64(buf[8:], lo)
+	return netip.AddrFrom16(buf)
+}
+
+// String returns the value in decimal.
+func (t *Uint128) String() string {
+	return (*big.Int)(t).String()
+}
+
 // Copy make a deep copy of the Uint128.
 func (t *Uint128) Copy() DataType {
 	nv := big.Int{}
@@ -670,6 +849,16 @@ func (t *Uint128) Equal(other DataType) bool {
 	return ok && (*big.Int)(t).Cmp((*big.Int)(otherT)) == 0
 }
 
+var maxUint128 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 128), big.NewInt(1))
+
+func (t *Uint128) validate() error {
+	v := (*big.Int)(t)
+	if v.Sign() < 0 || v.BitLen() > 128 {
+		return fmt.Errorf("uint128 out of range: %s", v)
+	}
+	return nil
+}
+
 func (t *Uint128) size() int {
 	// We add 7 here as we want the ceiling of the division operation rather
 	// than the floor.
@@ -682,15 +871,23 @@ func (t *Uint128) typeNum() typeNum {
 
 // WriteTo writes the value to w.
 func (t *Uint128) WriteTo(w writer) (int64, error) {
+	if err := t.validate(); err != nil {
+		return 0, err
+	}
+
 	numBytes, err := writeCtrlByte(w, t)
 	if err != nil {
 		return numBytes, err
 	}
 
-	written, err := w.Write((*big.Int)(t).Bytes())
-	numBytes += int64(written)
-	if err != nil {
-		return numBytes, fmt.Errorf("writing uint128: %w", err)
+	// We use a fixed-size buffer rather than Bytes to avoid allocating.
+	var buf [16]byte
+	(*big.Int)(t).FillBytes(buf[:])
+	for _, b := range buf[len(buf)-t.size():] {
+		if err := w.WriteByte(b); err != nil {
+			return numBytes, fmt.Errorf("writing uint128: %w", err)
+		}
+		numBytes++
 	}
 	return numBytes, nil
 }
@@ -3,7 +3,9 @@ package mmdbtype
 import (
 	"bytes"
 	"encoding/hex"
+	"math"
 	"math/big"
+	"net/netip"
 	"strings"
 	"testing"
 
@@ -88,6 +90,66 @@ func TestMap(t *testing.T) {
 	validateEncoding(t, maps)
 }
 
+func TestMapKeyOrder(t *testing.T) {
+	m := Map{
+		"a":       Bool(true),
+		"b":       Bool(true),
+		"city":    Bool(true),
+		"country": Bool(true),
+	}
+	tests := []struct {
+		name     string
+		order    KeyOrder
+		expected []String
+	}{
+		{
+			name:     "default",
+			expected: []String{"a", "b", "city", "country"},
+		},
+		{
+			name:     "priority",
+			order:    PriorityKeyOrder("country", "city", "country"),
+			expected: []String{"country", "city", "a", "b"},
+		},
+		{
+			name: "ties in order by key value",
+			order: func(a, b String) bool {
+				return len(a) < len(b)
+			},
+			expected: []String{"a", "b", "city", "country"},
+		},
+		{
+			name: "reversed",
+			order: func(a, b String) bool {
+				return a > b
+			},
+			expected: []String{"country", "city", "b", "a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &orderedDataWriter{
+				dataWriter: dataWriter{Buffer: &bytes.Buffer{}},
+				order:      test.order,
+			}
+			_, err := m.WriteTo(w)
+			require.NoError(t, err)
+
+			expected := &dataWriter{Buffer: &bytes.Buffer{}}
+			_, err = writeCtrlByte(expected, m)
+			require.NoError(t, err)
+			for _, k := range test.expected {
+				_, err = k.WriteTo(expected)
+				require.NoError(t, err)
+				_, err = m[k].WriteTo(expected)
+				require.NoError(t, err)
+			}
+			assert.Equal(t, expected.Bytes(), w.Bytes())
+		})
+	}
+}
+
 func TestPointers(t *testing.T) {
 	pointers := map[string]DataType{
 		"2000":       Pointer(0),
@@ -214,6 +276,71 @@ func TestUint128(t *testing.T) {
 	validateEncoding(t, uints)
 }
 
+var uint128Sink *Uint128
+
+func TestUint128Helpers(t *testing.T) {
+	v := NewUint128(0x20010db800000000, 1)
+	assert.Equal(t, "42540766411282592856903984951653826561", v.String())
+
+	hi, lo := v.Uint64s()
+	assert.Equal(t, uint64(0x20010db800000000), hi)
+	assert.Equal(t, uint64(1), lo)
+
+	addr := netip.MustParseAddr("2001:db8::1")
+	assert.True(t, v.Equal(Uint128FromAddr(addr)))
+	assert.Equal(t, addr, v.Addr())
+
+	assert.True(
+		t,
+		NewUint128(0, 0x01020304).Equal(Uint128FromAddr(netip.MustParseAddr("1.2.3.4"))),
+		"IPv4 addresses are 32-bit values",
+	)
+
+	for s, expected := range map[string]*Uint128{
+		"0":                                  NewUint128(0, 0),
+		"500":                                NewUint128(0, 500),
+		"0x20010db8000000000000000000000001": v,
+		"340282366920938463463374607431768211455": NewUint128(math.MaxUint64, math.MaxUint64),
+	} {
+		actual, err := ParseUint128(s)
+		require.NoError(t, err, s)
+		assert.True(t, expected.Equal(actual), s)
+	}
+
+	for _, s := range []string{"", "abc", "-1", "+1", "0x", "0x_", "0b12", "340282366920938463463374607431768211456"} {
+		_, err := ParseUint128(s)
+		assert.Error(t, err, s)
+	}
+
+	hi, lo = (*Uint128)(new(big.Int).Lsh(big.NewInt(3), 128)).Uint64s()
+	assert.Equal(t, [2]uint64{0, 0}, [2]uint64{hi, lo}, "values that are out of range are truncated")
+
+	w := &dataWriter{Buffer: &bytes.Buffer{}}
+	_, err := (*Uint128)(big.NewInt(-1)).WriteTo(w)
+	assert.EqualError(t, err, "uint128 out of range: -1")
+
+	// Each constructor only allocates the value it returns.
+	for name, f := range map[string]func() *Uint128{
+		"NewUint128":      func() *Uint128 { return NewUint128(1, 2) },
+		"Uint128FromAddr": func() *Uint128 { return Uint128FromAddr(addr) },
+		"ParseUint128": func() *Uint128 {
+			v, _ := ParseUint128("0x20010db8000000000000000000000001")
+			return v
+		},
+	} {
+		allocs := testing.AllocsPerRun(100, func() { uint128Sink = f() })
+		assert.Equal(t, float64(1), allocs, name)
+	}
+
+	w.Grow(1024)
+	allocs := testing.AllocsPerRun(100, func() {
+		w.Reset()
+		_, err := v.WriteTo(w)
+		require.NoError(t, err)
+	})
+	assert.Zero(t, allocs, "WriteTo does not allocate")
+}
+
 func TestEqual(t *testing.T) {
 	sameMap := Map{"same": String("map")}
 	sameSlice := Slice{String("same")}
@@ -471,3 +598,16 @@ type dataWriter struct {
 func (dw *dataWriter) WriteOrWritePointer(t DataType) (int64, error) {
 	return t.WriteTo(dw)
 }
+
+type orderedDataWriter struct {
+	dataWriter
+	order KeyOrder
+}
+
+func (dw *orderedDataWriter) KeyOrder() KeyOrder {
+	return dw.order
+}
+
+func (dw *orderedDataWriter) WriteOrWritePointer(t DataType) (int64, error) {
+	return t.WriteTo(dw)
+}
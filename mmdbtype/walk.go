@@ -0,0 +1,175 @@
+package mmdbtype
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// PathElem is an element of the path to a value nested in a Map or Slice.
+type PathElem struct {
+	// Key is the key of the value in its parent Map. It is only set if the
+	// parent is a Map.
+	Key String
+
+	// Index is the index of the value in its parent Slice. It is -1 if the
+	// parent is a Map.
+	Index int
+}
+
+// IsIndex reports whether the element is a Slice index rather than a Map
+// key.
+func (e PathElem) IsIndex() bool {
+	return e.Index >= 0
+}
+
+func (e PathElem) String() string {
+	if e.IsIndex() {
+		return strconv.Itoa(e.Index)
+	}
+	return string(e.Key)
+}
+
+// SkipChildren may be returned by the function passed to Walk to skip the
+// values nested in the current Map or Slice. It is not returned as an
+// error by Walk.
+var SkipChildren = errors.New("skip children") //nolint:errname,revive,stylecheck // like fs.SkipDir
+
+// WalkFunc is called by Walk for each value. path is the path to the
+// value from the value passed to Walk, which has an empty path. The path
+// is reused between calls and must not be retained.
+type WalkFunc func(path []PathElem, v DataType) error
+
+// Walk calls fn for v and each value nested in it, visiting each Map or
+// Slice before the values in it. The values in a Map are visited in order
+// by key so that the order is deterministic. If fn returns SkipChildren
+// for a Map or Slice, the values in it are skipped. If fn returns any other
+// error, Walk stops and returns the error.
+func Walk(v DataType, fn WalkFunc) error {
+	err := walk(nil, v, fn)
+	if errors.Is(err, SkipChildren) {
+		return nil
+	}
+	return err
+}
+
+func walk(path []PathElem, v DataType, fn WalkFunc) error {
+	if err := fn(path, v); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case Map:
+		for _, k := range sortedKeys(v) {
+			err := walk(append(path, PathElem{Key: k, Index: -1}), v[k], fn)
+			if err != nil && !errors.Is(err, SkipChildren) {
+				return err
+			}
+		}
+	case Slice:
+		for i, e := range v {
+			err := walk(append(path, PathElem{Index: i}), e, fn)
+			if err != nil && !errors.Is(err, SkipChildren) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TransformFunc is called by Transform for each value. path is the path to
+// the value from the value passed to Transform, which has an empty path.
+// The path is reused between calls and must not be retained. It returns
+// the value that replaces v. Returning nil removes v from its parent.
+type TransformFunc func(path []PathElem, v DataType) (DataType, error)
+
+// Transform rebuilds v by calling fn for v and each value nested in it,
+// visiting the values in each Map or Slice before the Map or Slice itself.
+// As such, fn receives each Map and Slice with its values already
+// transformed.
+//
+// The values passed to Transform are never modified. A Map or Slice is
+// copied only if a value in it was replaced, so unchanged values are shared
+// between the input and the result. Functions passed to Transform must
+// likewise not modify the values passed to them.
+func Transform(v DataType, fn TransformFunc) (DataType, error) {
+	return transform(nil, v, fn)
+}
+
+func transform(path []PathElem, v DataType, fn TransformFunc) (DataType, error) {
+	switch v := v.(type) {
+	case Map:
+		var newMap Map
+		for _, k := range sortedKeys(v) {
+			nv, err := transform(append(path, PathElem{Key: k, Index: -1}), v[k], fn)
+			if err != nil {
+				return nil, err
+			}
+			if sameValue(v[k], nv) {
+				continue
+			}
+			if newMap == nil {
+				newMap = make(Map, len(v))
+				for k, e := range v {
+					newMap[k] = e
+				}
+			}
+			if nv == nil {
+				delete(newMap, k)
+			} else {
+				newMap[k] = nv
+			}
+		}
+		if newMap != nil {
+			return fn(path, newMap)
+		}
+	case Slice:
+		var newSlice Slice
+		for i, e := range v {
+			ne, err := transform(append(path, PathElem{Index: i}), e, fn)
+			if err != nil {
+				return nil, err
+			}
+			if newSlice == nil {
+				if sameValue(e, ne) {
+					continue
+				}
+				newSlice = make(Slice, i, len(v))
+				copy(newSlice, v[:i])
+			}
+			if ne != nil {
+				newSlice = append(newSlice, ne)
+			}
+		}
+		if newSlice != nil {
+			return fn(path, newSlice)
+		}
+	}
+	return fn(path, v)
+}
+
+// sameValue reports whether a and b are the same value, as opposed to
+// equal values. It is used to avoid copying a Map or Slice when none of its
+// values changed.
+func sameValue(a, b DataType) bool {
+	switch a := a.(type) {
+	case Map, Slice, Bytes:
+		if reflect.TypeOf(a) != reflect.TypeOf(b) {
+			return false
+		}
+		av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+		return av.Pointer() == bv.Pointer() && av.Len() == bv.Len()
+	default:
+		return a == b
+	}
+}
+
+func sortedKeys(m Map) []String {
+	keys := make([]String, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
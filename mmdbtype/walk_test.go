@@ -0,0 +1,107 @@
+package mmdbtype
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func pathString(path []PathElem) string {
+	elems := make([]string, len(path))
+	for i, e := range path {
+		elems[i] = e.String()
+	}
+	return "/" + strings.Join(elems, "/")
+}
+
+func TestWalk(t *testing.T) {
+	v := Map{
+		"b": Slice{String("x"), Map{"c": Uint16(1)}},
+		"a": Map{"d": Bool(true)},
+	}
+
+	var visited []string
+	err := Walk(v, func(path []PathElem, v DataType) error {
+		visited = append(visited, pathString(path))
+		if len(path) == 1 && path[0].Key == "a" {
+			return SkipChildren
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/", "/a", "/b", "/b/0", "/b/1", "/b/1/c"}, visited)
+
+	errStop := errors.New("stop")
+	visited = nil
+	err = Walk(v, func(path []PathElem, v DataType) error {
+		visited = append(visited, pathString(path))
+		if len(path) == 2 && path[1].IsIndex() {
+			return errStop
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, []string{"/", "/a", "/a/d", "/b", "/b/0"}, visited)
+}
+
+func TestTransform(t *testing.T) {
+	unchanged := Map{"keep": String("me")}
+	v := Map{
+		"unchanged": unchanged,
+		"slice":     Slice{String("drop"), Uint16(1), String("drop"), Uint16(2)},
+		"drop":      String("drop"),
+		"nested":    Map{"n": Uint16(3)},
+	}
+	original := v.Copy()
+
+	var paths []string
+	result, err := Transform(v, func(path []PathElem, v DataType) (DataType, error) {
+		paths = append(paths, pathString(path))
+		switch v := v.(type) {
+		case String:
+			if v == "drop" {
+				return nil, nil
+			}
+		case Uint16:
+			return v * 10, nil
+		}
+		return v, nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(
+		t,
+		Map{
+			"unchanged": Map{"keep": String("me")},
+			"slice":     Slice{Uint16(10), Uint16(20)},
+			"nested":    Map{"n": Uint16(30)},
+		},
+		result,
+	)
+	assert.Equal(t, original, v, "the input is not modified")
+	assert.True(t, sameValue(unchanged, result.(Map)["unchanged"]), "unchanged values are shared")
+	assert.Equal(
+		t,
+		[]string{
+			"/drop",
+			"/nested/n", "/nested",
+			"/slice/0", "/slice/1", "/slice/2", "/slice/3", "/slice",
+			"/unchanged/keep", "/unchanged",
+			"/",
+		},
+		paths,
+		"values are visited before their parents",
+	)
+
+	errStop := errors.New("stop")
+	_, err = Transform(v, func(path []PathElem, v DataType) (DataType, error) {
+		if len(path) == 2 {
+			return nil, errStop
+		}
+		return v, nil
+	})
+	assert.ErrorIs(t, err, errStop)
+}
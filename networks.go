@@ -0,0 +1,156 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"errors"
+	"net"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// NetworkFunc is called by Networks and NetworksWithin for each network in
+// the tree that has a value. The network may be retained, but the value
+// must not be modified as it may be shared with other networks.
+type NetworkFunc func(network *net.IPNet, value mmdbtype.DataType) error
+
+// NetworksOption configures the behavior of Networks and NetworksWithin.
+type NetworksOption func(*networksOptions)
+
+type networksOptions struct {
+	includeAliasedNetworks bool
+}
+
+// IncludeAliasedNetworks causes Networks and NetworksWithin to also report
+// the networks within the IPv6 networks that are aliased to the IPv4
+// subtree, e.g., ::ffff:1.1.1.0/120 in addition to 1.1.1.0/24. By default,
+// each network in the IPv4 subtree is only reported once.
+func IncludeAliasedNetworks() NetworksOption {
+	return func(o *networksOptions) {
+		o.includeAliasedNetworks = true
+	}
+}
+
+// Networks calls fn for each network in the tree that has a value, in
+// order by network. Networks in the IPv4 subtree of an IPv6 tree, i.e.,
+// ::/96, are reported as IPv4 networks, matching Get and
+// github.com/oschwald/maxminddb-golang. Iteration stops when fn returns an
+// error, which is returned by Networks.
+//
+// The tree must not be modified while iterating over it. This is not safe
+// to call from multiple threads while the tree is being modified.
+func (t *Tree) Networks(fn NetworkFunc, opts ...NetworksOption) error {
+	all := &net.IPNet{
+		IP:   make(net.IP, t.treeDepth/8),
+		Mask: net.CIDRMask(0, t.treeDepth),
+	}
+	return t.NetworksWithin(all, fn, opts...)
+}
+
+// NetworksWithin is the same as Networks, except that only the networks
+// within the provided network are reported. If the provided network is
+// part of a larger network with a value, the larger network is reported.
+func (t *Tree) NetworksWithin(network *net.IPNet, fn NetworkFunc, opts ...NetworksOption) error {
+	o := networksOptions{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	prefixLen, bits := network.Mask.Size()
+	ip := network.IP
+	if len(ip) == 4 {
+		if bits != 32 {
+			return errors.New("invalid network mask")
+		}
+		if t.treeDepth == 128 {
+			ip = ipV4ToV6(ip)
+			prefixLen += 96
+		}
+	} else {
+		if bits != 128 {
+			return errors.New("invalid network mask")
+		}
+		if t.treeDepth == 32 {
+			return errors.New("cannot search for an IPv6 network in an IPv4 tree")
+		}
+	}
+	// The iterator sets the bits of the IP as it goes.
+	ip = ip.Mask(net.CIDRMask(prefixLen, t.treeDepth))
+
+	it := &networkIterator{
+		tree:                   t,
+		fn:                     fn,
+		includeAliasedNetworks: o.includeAliasedNetworks,
+	}
+
+	n := rootNode
+	for depth := 0; depth < prefixLen; depth++ {
+		r := t.nodes.nodes[n].children[bitAt(ip, depth)]
+		switch r.recordType() {
+		case recordTypeAlias:
+			if !it.includeAliasedNetworks {
+				return nil
+			}
+			n = r.index()
+		case recordTypeNode, recordTypeFixedNode:
+			n = r.index()
+		case recordTypeData:
+			return it.report(ip.Mask(net.CIDRMask(depth+1, t.treeDepth)), depth+1, r)
+		default:
+			return nil
+		}
+	}
+	return it.visitNode(n, ip, prefixLen)
+}
+
+// networkIterator walks the nodes of a tree in depth-first order.
+type networkIterator struct {
+	tree                   *Tree
+	fn                     NetworkFunc
+	includeAliasedNetworks bool
+}
+
+// visitNode visits the records of node n, which is at the provided depth.
+// The bits of ip below the depth must be zero. They are zero again when
+// visitNode returns.
+func (it *networkIterator) visitNode(n uint32, ip net.IP, depth int) error {
+	for bit := byte(0); bit < 2; bit++ {
+		r := it.tree.nodes.nodes[n].children[bit]
+		mask := byte(1) << (7 - depth%8)
+		if bit == 1 {
+			ip[depth/8] |= mask
+		}
+
+		var err error
+		switch r.recordType() {
+		case recordTypeAlias:
+			if it.includeAliasedNetworks {
+				err = it.visitNode(r.index(), ip, depth+1)
+			}
+		case recordTypeNode, recordTypeFixedNode:
+			err = it.visitNode(r.index(), ip, depth+1)
+		case recordTypeData:
+			err = it.report(ip, depth+1, r)
+		}
+
+		ip[depth/8] &^= mask
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (it *networkIterator) report(ip net.IP, prefixLen int, r record) error {
+	bits := it.tree.treeDepth
+	if bits == 128 && prefixLen >= 96 && bytes.Equal(ip[:12], v4Prefix) {
+		ip = ip[12:]
+		prefixLen -= 96
+		bits = 32
+	}
+
+	network := &net.IPNet{
+		IP:   append(net.IP(nil), ip...),
+		Mask: net.CIDRMask(prefixLen, bits),
+	}
+	return it.fn(network, it.tree.dataMap.get(r.index()).data)
+}
@@ -0,0 +1,98 @@
+package mmdbwriter
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworks(t *testing.T) {
+	tree, err := New(Options{IncludeReservedNetworks: true})
+	require.NoError(t, err)
+
+	for network, value := range map[string]string{
+		"1.1.1.0/24":    "a",
+		"1.1.2.0/23":    "b",
+		"2001:db8::/32": "c",
+		"10.0.0.0/8":    "d",
+	} {
+		_, n, err := net.ParseCIDR(network)
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(n, mmdbtype.String(value)))
+	}
+
+	collect := func(network string, opts ...NetworksOption) []string {
+		var networks []string
+		fn := func(n *net.IPNet, v mmdbtype.DataType) error {
+			// IPNet.String formats IPv4-mapped networks as IPv4 networks.
+			addr, ok := netip.AddrFromSlice(n.IP)
+			require.True(t, ok)
+			ones, _ := n.Mask.Size()
+			networks = append(networks, netip.PrefixFrom(addr, ones).String()+"="+string(v.(mmdbtype.String)))
+			return nil
+		}
+		if network == "" {
+			require.NoError(t, tree.Networks(fn, opts...))
+			return networks
+		}
+		_, n, err := net.ParseCIDR(network)
+		require.NoError(t, err)
+		require.NoError(t, tree.NetworksWithin(n, fn, opts...))
+		return networks
+	}
+
+	assert.Equal(
+		t,
+		[]string{"1.1.1.0/24=a", "1.1.2.0/23=b", "10.0.0.0/8=d", "2001:db8::/32=c"},
+		collect(""),
+	)
+	assert.Equal(t, []string{"1.1.1.0/24=a", "1.1.2.0/23=b"}, collect("1.1.0.0/16"))
+	assert.Equal(t, []string{"10.0.0.0/8=d"}, collect("10.1.0.0/16"), "the larger network is reported")
+	assert.Empty(t, collect("2.0.0.0/8"))
+	assert.Equal(t, []string{"1.1.1.0/24=a"}, collect("::1.1.1.0/120"))
+
+	assert.Empty(t, collect("::ffff:1.1.0.0/112"), "aliased networks are skipped by default")
+	assert.Equal(
+		t,
+		[]string{"::ffff:1.1.1.0/120=a", "::ffff:1.1.2.0/119=b"},
+		collect("::ffff:1.1.0.0/112", IncludeAliasedNetworks()),
+	)
+
+	all := collect("", IncludeAliasedNetworks())
+	assert.Len(t, all, 1+3*4, "each IPv4 network is aliased three times")
+	assert.Contains(t, all, "2002:101:100::/40=a")
+
+	errStop := errors.New("stop")
+	calls := 0
+	err = tree.Networks(func(*net.IPNet, mmdbtype.DataType) error {
+		calls++
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, 1, calls)
+}
+
+func TestNetworksIPv4(t *testing.T) {
+	tree, err := New(Options{IPVersion: 4})
+	require.NoError(t, err)
+
+	_, n, err := net.ParseCIDR("1.1.1.0/24")
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(n, mmdbtype.Uint32(1)))
+
+	var networks []string
+	require.NoError(t, tree.Networks(func(n *net.IPNet, _ mmdbtype.DataType) error {
+		networks = append(networks, n.String())
+		return nil
+	}))
+	assert.Equal(t, []string{"1.1.1.0/24"}, networks)
+
+	_, n, err = net.ParseCIDR("2001:db8::/32")
+	require.NoError(t, err)
+	assert.Error(t, tree.NetworksWithin(n, func(*net.IPNet, mmdbtype.DataType) error { return nil }))
+}
@@ -1,6 +1,7 @@
 package mmdbwriter
 
 import (
+	"errors"
 	"fmt"
 	"net"
 
@@ -18,23 +19,160 @@ const (
 	recordTypeReserved
 )
 
-type record struct {
-	node       *node
-	value      *dataMapValue
-	recordType recordType
+const (
+	recordTypeBits  = 3
+	recordIndexBits = 32 - recordTypeBits
+
+	// maxRecordIndex is the largest node or value index that may be stored
+	// in a record.
+	maxRecordIndex = 1<<recordIndexBits - 1
+)
+
+// record is a tagged union of the record type and an index. The type is
+// stored in the top recordTypeBits bits. The remaining bits contain the
+// index of the node in the nodeArena for node, alias, and fixed node
+// records, the index of the value in the dataMap for data records, and
+// zero otherwise.
+type record uint32
+
+func newRecord(rt recordType, index uint32) record {
+	return record(uint32(rt)<<recordIndexBits | index)
+}
+
+func (r record) recordType() recordType {
+	return recordType(r >> recordIndexBits)
+}
+
+func (r record) index() uint32 {
+	return uint32(r) & maxRecordIndex
 }
 
 // each node contains two records.
 type node struct {
 	children [2]record
-	nodeNum  int
+}
+
+// rootNode is the index of the root node in the nodeArena.
+const rootNode uint32 = 0
+
+// nodeArena holds the nodes of a tree. Nodes refer to each other by their
+// index in the arena rather than by pointer. This allows records to be
+// stored in 32 bits and avoids the overhead of allocating millions of small
+// objects.
+type nodeArena struct {
+	nodes []node
+	// free contains the indexes of nodes that are no longer used and that
+	// may be reused by alloc.
+	free []uint32
+	// changed is set when a node is added or removed. Otherwise, changes
+	// since the last compaction were limited to the values of records,
+	// which leaves the nodes in depth-first order.
+	changed bool
+}
+
+// alloc adds n to the arena and returns its index. If j is set, the node
+// is always appended to the arena so that the allocation may be undone.
+func (a *nodeArena) alloc(n node, j *journal) (uint32, error) {
+	a.changed = true
+	if j == nil && len(a.free) > 0 {
+		idx := a.free[len(a.free)-1]
+		a.free = a.free[:len(a.free)-1]
+		a.nodes[idx] = n
+		return idx, nil
+	}
+	if len(a.nodes) > maxRecordIndex {
+		return 0, errors.New("the tree has exceeded the maximum number of nodes")
+	}
+	idx := uint32(len(a.nodes))
+	a.nodes = append(a.nodes, n)
+	j.allocatedNode(idx)
+	return idx, nil
+}
+
+// release marks the node as unused. If j is set, the node is only made
+// available for reuse once the transaction is committed.
+func (a *nodeArena) release(idx uint32, j *journal) {
+	a.changed = true
+	if j != nil {
+		j.releasedNode(idx)
+		return
+	}
+	a.free = append(a.free, idx)
+}
+
+// compact rebuilds the arena so that it contains only the nodes reachable
+// from the root, numbered in depth-first order. As such, the index of each
+// node is its node number in the search tree. It returns the number of
+// nodes. If no nodes were added or removed since the last compaction, the
+// nodes are already in order and are left as they are.
+func (a *nodeArena) compact() int {
+	if !a.changed {
+		return len(a.nodes)
+	}
+	a.changed = false
+
+	c := arenaCompactor{
+		old:        a.nodes,
+		nodes:      make([]node, 0, len(a.nodes)-len(a.free)),
+		fixedNodes: map[uint32]uint32{},
+	}
+	c.copy(rootNode)
+
+	for _, alias := range c.aliases {
+		r := c.nodes[alias.node].children[alias.pos]
+		c.nodes[alias.node].children[alias.pos] = newRecord(
+			recordTypeAlias,
+			c.fixedNodes[r.index()],
+		)
+	}
+
+	a.nodes = c.nodes
+	a.free = nil
+	return len(a.nodes)
+}
+
+type recordLocation struct {
+	node uint32
+	pos  byte
+}
+
+type arenaCompactor struct {
+	old   []node
+	nodes []node
+	// fixedNodes maps the old index of each fixed node to its new one.
+	fixedNodes map[uint32]uint32
+	// aliases are the alias records, which must be updated to point at the
+	// new index of the fixed node once all nodes have been copied.
+	aliases []recordLocation
+}
+
+func (c *arenaCompactor) copy(old uint32) uint32 {
+	idx := uint32(len(c.nodes))
+	c.nodes = append(c.nodes, c.old[old])
+	for pos := byte(0); pos < 2; pos++ {
+		r := c.nodes[idx].children[pos]
+		switch r.recordType() {
+		case recordTypeNode:
+			c.nodes[idx].children[pos] = newRecord(recordTypeNode, c.copy(r.index()))
+		case recordTypeFixedNode:
+			newIdx := c.copy(r.index())
+			c.fixedNodes[r.index()] = newIdx
+			c.nodes[idx].children[pos] = newRecord(recordTypeFixedNode, newIdx)
+		case recordTypeAlias:
+			c.aliases = append(c.aliases, recordLocation{node: idx, pos: pos})
+		default:
+		}
+	}
+	return idx
 }
 
 type insertRecord struct {
 	inserter func(value mmdbtype.DataType) (mmdbtype.DataType, error)
 
+	nodes        *nodeArena
 	dataMap      *dataMap
-	insertedNode *node
+	insertedNode uint32
+	journal      *journal
 
 	ip        net.IP
 	prefixLen int
@@ -42,104 +180,96 @@ type insertRecord struct {
 	recordType recordType
 }
 
-func (n *node) insert(iRec insertRecord, currentDepth int) error {
+// setRecord sets the record at pos in node n, recording the change in the
+// journal.
+func (iRec *insertRecord) setRecord(n uint32, pos byte, r record) {
+	iRec.journal.changedRecord(n, pos, iRec.nodes.nodes[n].children[pos])
+	iRec.nodes.nodes[n].children[pos] = r
+}
+
+func (iRec *insertRecord) insertIntoNode(n uint32, currentDepth int) error {
 	newDepth := currentDepth + 1
 	// Check if we are inside the network already
 	if newDepth > iRec.prefixLen {
 		// Data already exists for the network so insert into all the children.
 		// We will prune duplicate nodes when we finalize.
-		err := n.children[0].insert(iRec, newDepth)
+		err := iRec.insertIntoRecord(n, 0, newDepth)
 		if err != nil {
 			return err
 		}
-		return n.children[1].insert(iRec, newDepth)
+		return iRec.insertIntoRecord(n, 1, newDepth)
 	}
 
 	// We haven't reached the network yet.
 	pos := bitAt(iRec.ip, currentDepth)
-	r := &n.children[pos]
-	return r.insert(iRec, newDepth)
+	return iRec.insertIntoRecord(n, pos, newDepth)
 }
 
-func (r *record) insert(
-	iRec insertRecord,
+// insertIntoRecord inserts into the record at pos in node n. Records are
+// referred to by their location rather than by pointer as allocating a
+// node may move the other nodes in the arena.
+func (iRec *insertRecord) insertIntoRecord(
+	n uint32,
+	pos byte,
 	newDepth int,
 ) error {
-	switch r.recordType {
+	r := iRec.nodes.nodes[n].children[pos]
+	switch r.recordType() {
 	case recordTypeNode:
-		err := r.node.insert(iRec, newDepth)
+		child := r.index()
+		err := iRec.insertIntoNode(child, newDepth)
 		if err != nil {
 			return err
 		}
 
 		// Check to see if the children are the same and can be merged.
-		child0 := r.node.children[0]
-		child1 := r.node.children[1]
-		if child0.recordType != child1.recordType {
+		child0 := iRec.nodes.nodes[child].children[0]
+		child1 := iRec.nodes.nodes[child].children[1]
+		if child0.recordType() != child1.recordType() {
 			return nil
 		}
-		switch child0.recordType {
+		switch child0.recordType() {
 		// Nodes can't be merged
 		case recordTypeFixedNode,
 			recordTypeNode:
 			return nil
 		case recordTypeEmpty,
 			recordTypeReserved:
-			r.recordType = child0.recordType
-			r.node = nil
+			iRec.setRecord(n, pos, child0)
+			iRec.nodes.release(child, iRec.journal)
 			return nil
 		case recordTypeData:
-			if child0.value.key != child1.value.key {
+			// As values are deduplicated, the records have the same data
+			// if and only if they have the same index.
+			if child0 != child1 {
 				return nil
 			}
 			// Children have same data and can be merged
-			r.recordType = recordTypeData
-			r.value = child0.value
-			iRec.dataMap.remove(child1.value)
-			r.node = nil
+			iRec.setRecord(n, pos, child0)
+			iRec.dataMap.remove(child1.index(), iRec.journal)
+			iRec.nodes.release(child, iRec.journal)
 			return nil
 		default:
-			return fmt.Errorf("merging record type %d is not implemented", child0.recordType)
+			return fmt.Errorf("merging record type %d is not implemented", child0.recordType())
 		}
 	case recordTypeFixedNode:
-		return r.node.insert(iRec, newDepth)
+		return iRec.insertIntoNode(r.index(), newDepth)
 	case recordTypeEmpty, recordTypeData:
 		if newDepth >= iRec.prefixLen {
-			r.node = iRec.insertedNode
-			r.recordType = iRec.recordType
-			if iRec.recordType == recordTypeData {
-				var oldData mmdbtype.DataType
-				if r.value != nil {
-					oldData = r.value.data
-				}
-				newData, err := iRec.inserter(oldData)
-				if err != nil {
-					return err
-				}
-				if newData == nil {
-					iRec.dataMap.remove(r.value)
-					r.recordType = recordTypeEmpty
-					r.value = nil
-				} else if oldData == nil || !oldData.Equal(newData) {
-					iRec.dataMap.remove(r.value)
-					value, err := iRec.dataMap.store(newData)
-					if err != nil {
-						return err
-					}
-					r.value = value
-				}
-			} else {
-				r.value = nil
-			}
-			return nil
+			return iRec.replaceRecord(n, pos, r)
 		}
 
 		// We are splitting this record so we create two duplicate child
-		// records.
-		r.node = &node{children: [2]record{*r, *r}}
-		r.value = nil
-		r.recordType = recordTypeNode
-		return r.node.insert(iRec, newDepth)
+		// records. Each holds its own reference to the value, if any.
+		if r.recordType() == recordTypeData {
+			iRec.dataMap.addRef(r.index(), iRec.journal)
+		}
+		child, err := iRec.nodes.alloc(node{children: [2]record{r, r}}, iRec.journal)
+		if err != nil {
+			return err
+		}
+		iRec.setRecord(n, pos, newRecord(recordTypeNode, child))
+		return iRec.insertIntoNode(child, newDepth)
 	case recordTypeReserved:
 		if iRec.prefixLen >= newDepth {
 			return fmt.Errorf(
@@ -164,44 +294,70 @@ func (r *record) insert(
 			iRec.prefixLen,
 		)
 	default:
-		return fmt.Errorf("inserting into record type %d is not implemented", r.recordType)
+		return fmt.Errorf("inserting into record type %d is not implemented", r.recordType())
+	}
+}
+
+// replaceRecord replaces the empty or data record r at pos in node n with
+// the inserted record.
+func (iRec *insertRecord) replaceRecord(n uint32, pos byte, r record) error {
+	if iRec.recordType != recordTypeData {
+		if r.recordType() == recordTypeData {
+			iRec.dataMap.remove(r.index(), iRec.journal)
+		}
+		iRec.setRecord(n, pos, newRecord(iRec.recordType, iRec.insertedNode))
+		return nil
+	}
+
+	var oldData mmdbtype.DataType
+	if r.recordType() == recordTypeData {
+		oldData = iRec.dataMap.get(r.index()).data
+	}
+	newData, err := iRec.inserter(oldData)
+	if err != nil {
+		return err
+	}
+
+	if newData == nil {
+		if oldData != nil {
+			iRec.dataMap.remove(r.index(), iRec.journal)
+			iRec.setRecord(n, pos, newRecord(recordTypeEmpty, 0))
+		}
+		return nil
+	}
+
+	if oldData != nil && oldData.Equal(newData) {
+		return nil
+	}
+
+	if oldData != nil {
+		iRec.dataMap.remove(r.index(), iRec.journal)
+	}
+	value, err := iRec.dataMap.store(newData, iRec.journal)
+	if err != nil {
+		return err
 	}
+	iRec.setRecord(n, pos, newRecord(recordTypeData, value))
+	return nil
 }
 
-func (n *node) get(
+func (a *nodeArena) get(
 	ip net.IP,
+	n uint32,
 	depth int,
 ) (int, record) {
-	r := n.children[bitAt(ip, depth)]
+	r := a.nodes[n].children[bitAt(ip, depth)]
 
 	depth++
 
-	switch r.recordType {
+	switch r.recordType() {
 	case recordTypeNode, recordTypeAlias, recordTypeFixedNode:
-		return r.node.get(ip, depth)
+		return a.get(ip, r.index(), depth)
 	default:
 		return depth, r
 	}
 }
 
-// finalize  sets the node number for the node. It returns the current node
-// count, including the subtree.
-func (n *node) finalize(currentNum int) int {
-	n.nodeNum = currentNum
-	currentNum++
-
-	for i := 0; i < 2; i++ {
-		switch n.children[i].recordType {
-		case recordTypeFixedNode,
-			recordTypeNode:
-			currentNum = n.children[i].node.finalize(currentNum)
-		default:
-		}
-	}
-
-	return currentNum
-}
-
 func bitAt(ip net.IP, depth int) byte {
 	return (ip[depth/8] >> (7 - (depth % 8))) & 1
 }
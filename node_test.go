@@ -0,0 +1,145 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"testing"
+	"unsafe"
+
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecord(t *testing.T) {
+	assert.Equal(t, uintptr(8), unsafe.Sizeof(node{}), "node size")
+
+	for _, rt := range []recordType{
+		recordTypeEmpty,
+		recordTypeData,
+		recordTypeNode,
+		recordTypeAlias,
+		recordTypeFixedNode,
+		recordTypeReserved,
+	} {
+		for _, idx := range []uint32{0, 1, 12345, maxRecordIndex} {
+			r := newRecord(rt, idx)
+			assert.Equal(t, rt, r.recordType())
+			assert.Equal(t, idx, r.index())
+		}
+	}
+}
+
+// TestRefCounts checks that the reference count of each value matches the
+// number of records that refer to it after a series of random inserts,
+// including inserts that split and merge records.
+func TestRefCounts(t *testing.T) {
+	tree, err := New(Options{IPVersion: 4})
+	require.NoError(t, err)
+
+	r := rand.New(rand.NewSource(0)) //nolint:gosec // test
+	for i := 0; i < 2_000; i++ {
+		network := &net.IPNet{
+			IP:   net.IPv4(1, byte(r.Intn(4)), byte(r.Intn(256)), 0).To4(),
+			Mask: net.CIDRMask(14+r.Intn(11), 32),
+		}
+		network.IP = network.IP.Mask(network.Mask)
+
+		var f inserter.Func
+		switch r.Intn(3) {
+		case 0:
+			f = inserter.Remove
+		case 1:
+			f = inserter.ReplaceWith(mmdbtype.Uint32(r.Intn(3)))
+		default:
+			f = func(v mmdbtype.DataType) (mmdbtype.DataType, error) {
+				if v == nil {
+					return mmdbtype.Uint32(0), nil
+				}
+				return mmdbtype.Uint32((v.(mmdbtype.Uint32) + 1) % 3), nil
+			}
+		}
+		require.NoError(t, tree.InsertFunc(network, f))
+	}
+
+	refCounts := map[uint32]uint32{}
+	for _, n := range reachableNodes(tree) {
+		for _, r := range n.children {
+			if r.recordType() == recordTypeData {
+				refCounts[r.index()]++
+			}
+		}
+	}
+
+	assert.Equal(t, len(refCounts), tree.dataMap.len())
+	for idx, count := range refCounts {
+		assert.Equal(t, count, tree.dataMap.get(idx).refCount, "refCount for %d", idx)
+	}
+}
+
+func reachableNodes(tree *Tree) []node {
+	var nodes []node
+	var walk func(uint32)
+	walk = func(idx uint32) {
+		n := tree.nodes.nodes[idx]
+		nodes = append(nodes, n)
+		for _, r := range n.children {
+			if r.recordType() == recordTypeNode || r.recordType() == recordTypeFixedNode {
+				walk(r.index())
+			}
+		}
+	}
+	walk(rootNode)
+	return nodes
+}
+
+func TestNodeReuse(t *testing.T) {
+	tree, err := New(
+		Options{
+			DatabaseType: "mmdbwriter-test",
+			Description:  map[string]string{"en": "Test database"},
+		},
+	)
+	require.NoError(t, err)
+
+	_, network, err := net.ParseCIDR("1.1.1.1/32")
+	require.NoError(t, err)
+
+	require.NoError(t, tree.Insert(network, mmdbtype.String("a")))
+	allocated := len(tree.nodes.nodes)
+
+	// Removing the network merges the nodes that were added for it, which
+	// makes them available for the next insert.
+	require.NoError(t, tree.InsertFunc(network, inserter.Remove))
+	assert.NotEmpty(t, tree.nodes.free)
+
+	require.NoError(t, tree.Insert(network, mmdbtype.String("b")))
+	assert.Len(t, tree.nodes.nodes, allocated, "freed nodes are reused")
+
+	buf := &bytes.Buffer{}
+	_, err = tree.WriteTo(buf)
+	require.NoError(t, err)
+
+	assert.Empty(t, tree.nodes.free, "finalizing compacts the nodes")
+	assert.Len(t, tree.nodes.nodes, tree.nodeCount)
+
+	checkMMDB(
+		t,
+		buf,
+		[]testGet{
+			{
+				ip:                  "1.1.1.1",
+				expectedNetwork:     "1.1.1.1/32",
+				expectedLookupValue: s2ip("b"),
+			},
+			{
+				ip:                  "2002:101:101::",
+				expectedNetwork:     "2002:101:101::/48",
+				expectedLookupValue: s2ip("b"),
+			},
+		},
+		"MMDB lookups after node reuse",
+	)
+}
@@ -0,0 +1,63 @@
+package mmdbwriter
+
+import "context"
+
+// progressInterval is the number of networks or nodes processed between
+// checks of the context and calls to the ProgressFunc.
+const progressInterval = 1 << 12
+
+// Progress describes how far Load or WriteTo has gotten.
+type Progress struct {
+	// NetworksLoaded is the number of networks read from the source
+	// database by Load.
+	NetworksLoaded int
+
+	// NodesWritten is the number of search tree nodes written by WriteTo.
+	NodesWritten int
+
+	// BytesWritten is the number of bytes emitted by WriteTo. The search
+	// tree is counted as it is written. The data and metadata sections are
+	// counted once they have been written in full.
+	BytesWritten int64
+}
+
+// ProgressFunc receives progress updates from Load and WriteTo. It is
+// called from the goroutine doing the work and should return quickly.
+type ProgressFunc func(Progress)
+
+// progressTracker periodically checks the context for cancellation and
+// reports the progress.
+type progressTracker struct {
+	ctx      context.Context //nolint:containedctx // only lives for one call
+	progress ProgressFunc
+	current  Progress
+	steps    int
+}
+
+func newProgressTracker(ctx context.Context, progress ProgressFunc) *progressTracker {
+	return &progressTracker{ctx: ctx, progress: progress}
+}
+
+// step records that one unit of work has been completed. On the first step
+// and every progressInterval steps after it, it returns the context's error,
+// if any, and calls the ProgressFunc.
+func (p *progressTracker) step() error {
+	p.steps++
+	if p.steps%progressInterval != 1 {
+		return nil
+	}
+	if err := p.ctx.Err(); err != nil {
+		return err
+	}
+	if p.progress != nil {
+		p.progress(p.current)
+	}
+	return nil
+}
+
+// done reports the final progress.
+func (p *progressTracker) done() {
+	if p.progress != nil {
+		p.progress(p.current)
+	}
+}
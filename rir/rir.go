@@ -0,0 +1,267 @@
+// Package rir reads the statistics files in which the Regional Internet
+// Registries (AFRINIC, APNIC, ARIN, LACNIC, and RIPE NCC) publish the
+// address space they have delegated, e.g., delegated-apnic-extended-latest.
+// Both the standard and the extended format are supported.
+//
+// Each line of these files describes a block of IPv4 or IPv6 addresses or
+// of AS numbers:
+//
+//	apnic|AU|ipv4|1.0.0.0|256|20110811|assigned|A91872ED
+//	apnic|AU|ipv6|2001:dc0::|32|20020801|allocated|A91CBC82
+//
+// IPv4 blocks are given as a start address and a number of addresses,
+// which need not be a power of two, and are inserted as ranges. IPv6
+// blocks are given as a start address and a prefix length.
+package rir
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// Record is a block of IP addresses read from a statistics file.
+type Record struct {
+	// Registry is the registry that delegated the block, e.g., "apnic".
+	Registry string
+
+	// CountryCode is the ISO 3166 code of the country of the holder of the
+	// block, e.g., "AU". It is empty if the file does not give a country.
+	CountryCode string
+
+	// Start and End are the first and last addresses of an IPv4 block.
+	Start net.IP
+	End   net.IP
+
+	// Network is set rather than Start and End for an IPv6 block.
+	Network *net.IPNet
+
+	// Date is the date the block was delegated. It is the zero time if the
+	// file does not give a date.
+	Date time.Time
+
+	// Status is the status of the block, e.g., "allocated" or "assigned".
+	Status string
+
+	// OpaqueID identifies the holder of the block in extended files. Blocks
+	// with the same OpaqueID have the same holder.
+	OpaqueID string
+}
+
+// Data returns the data inserted for the record, e.g.:
+//
+//	{
+//	  "country": {"iso_code": "AU"},
+//	  "allocation": {
+//	    "registry": "apnic",
+//	    "status": "assigned",
+//	    "date": "2011-08-11",
+//	    "opaque_id": "A91872ED"
+//	  }
+//	}
+//
+// Values the file does not give are left out.
+func (r *Record) Data() mmdbtype.Map {
+	allocation := mmdbtype.Map{
+		"registry": mmdbtype.String(r.Registry),
+		"status":   mmdbtype.String(r.Status),
+	}
+	if !r.Date.IsZero() {
+		allocation["date"] = mmdbtype.String(r.Date.Format("2006-01-02"))
+	}
+	if r.OpaqueID != "" {
+		allocation["opaque_id"] = mmdbtype.String(r.OpaqueID)
+	}
+
+	data := mmdbtype.Map{"allocation": allocation}
+	if r.CountryCode != "" {
+		data["country"] = mmdbtype.Map{"iso_code": mmdbtype.String(r.CountryCode)}
+	}
+	return data
+}
+
+// Insert inserts the record into the tree using the inserter.Func returned
+// by the provided generator for the record's data.
+func (r *Record) Insert(tree *mmdbwriter.Tree, f inserter.FuncGenerator) error {
+	if r.Network != nil {
+		return tree.InsertFunc(r.Network, f(r.Data()))
+	}
+	return tree.InsertRangeFunc(r.Start, r.End, f(r.Data()))
+}
+
+func (r *Record) describe() string {
+	if r.Network != nil {
+		return r.Network.String()
+	}
+	return r.Start.String() + "-" + r.End.String()
+}
+
+// Reader reads Records from a statistics file. The version line, the
+// summary lines, comments, blank lines, and AS number blocks are skipped.
+type Reader struct {
+	// Statuses are the statuses of the blocks to read, e.g., "allocated".
+	// Blocks with other statuses, e.g., "available" or "reserved" blocks
+	// in extended files, are skipped. If it is empty, only allocated and
+	// assigned blocks are read.
+	Statuses []string
+
+	r    *bufio.Reader
+	line int
+}
+
+// NewReader returns a Reader that reads from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read returns the next record. It returns io.EOF when there are no more
+// records.
+func (r *Reader) Read() (*Record, error) {
+	for {
+		b, err := r.r.ReadBytes('\n')
+		if len(b) == 0 && err != nil {
+			return nil, err
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		r.line++
+
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 || b[0] == '#' {
+			continue
+		}
+		record, err := r.parse(strings.Split(string(b), "|"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", r.line, err)
+		}
+		if record != nil {
+			return record, nil
+		}
+	}
+}
+
+// parse returns the record on a line, or nil if the line is to be skipped.
+func (r *Reader) parse(fields []string) (*Record, error) {
+	// The version line starts with the version of the format, e.g., "2" or
+	// "2.3", and summary lines end with "summary".
+	if _, err := strconv.ParseFloat(fields[0], 64); err == nil {
+		return nil, nil
+	}
+	if len(fields) == 6 && fields[5] == "summary" {
+		return nil, nil
+	}
+	if len(fields) < 7 {
+		return nil, fmt.Errorf("expected at least 7 fields but found %d", len(fields))
+	}
+
+	typ, start, value := fields[2], fields[3], fields[4]
+	if typ == "asn" {
+		return nil, nil
+	}
+	if typ != "ipv4" && typ != "ipv6" {
+		return nil, fmt.Errorf("unknown type %q", typ)
+	}
+	status := strings.ToLower(fields[6])
+	if !r.wants(status) {
+		return nil, nil
+	}
+
+	record := &Record{
+		Registry: strings.ToLower(fields[0]),
+		Status:   status,
+	}
+	if cc := strings.ToUpper(fields[1]); cc != "ZZ" {
+		// ZZ is used for blocks that have not been delegated.
+		record.CountryCode = cc
+	}
+	if len(fields) > 7 {
+		record.OpaqueID = fields[7]
+	}
+
+	// Dates of 00000000 are used for blocks delegated before the
+	// registries recorded dates.
+	if date := fields[5]; date != "" && date != "00000000" {
+		t, err := time.Parse("20060102", date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q", date)
+		}
+		record.Date = t
+	}
+
+	ip := net.ParseIP(start)
+	if typ == "ipv4" {
+		if ip = ip.To4(); ip == nil {
+			return nil, fmt.Errorf("invalid IPv4 address %q", start)
+		}
+		count, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || count == 0 {
+			return nil, fmt.Errorf("invalid number of addresses %q", value)
+		}
+		first := uint64(ip[0])<<24 | uint64(ip[1])<<16 | uint64(ip[2])<<8 | uint64(ip[3])
+		if count > math.MaxUint32-first+1 {
+			return nil, fmt.Errorf("%d addresses starting at %s is past the end of the IPv4 space", count, ip)
+		}
+		last := first + count - 1
+		record.Start = ip
+		record.End = net.IPv4(byte(last>>24), byte(last>>16), byte(last>>8), byte(last)).To4()
+		return record, nil
+	}
+
+	if ip == nil || ip.To4() != nil {
+		return nil, fmt.Errorf("invalid IPv6 address %q", start)
+	}
+	bits, err := strconv.Atoi(value)
+	if err != nil || bits < 0 || bits > 128 {
+		return nil, fmt.Errorf("invalid prefix length %q", value)
+	}
+	mask := net.CIDRMask(bits, 128)
+	if !ip.Mask(mask).Equal(ip) {
+		return nil, fmt.Errorf("%s is not the first address of a /%d", ip, bits)
+	}
+	record.Network = &net.IPNet{IP: ip, Mask: mask}
+	return record, nil
+}
+
+func (r *Reader) wants(status string) bool {
+	if len(r.Statuses) == 0 {
+		return status == "allocated" || status == "assigned"
+	}
+	for _, s := range r.Statuses {
+		if strings.EqualFold(s, status) {
+			return true
+		}
+	}
+	return false
+}
+
+// Insert inserts each record read from r into the tree using the
+// inserter.Func returned by the provided generator for the record's data.
+// It returns the number of records inserted.
+func Insert(tree *mmdbwriter.Tree, r *Reader, f inserter.FuncGenerator) (int, error) {
+	count := 0
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if err := record.Insert(tree, f); err != nil {
+			return count, fmt.Errorf("line %d: inserting %s: %w", r.line, record.describe(), err)
+		}
+		count++
+	}
+}
@@ -0,0 +1,155 @@
+package rir
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const delegated = `# A comment.
+2.3|apnic|20240101|5|19830613|20231231|+1000
+apnic|*|asn|*|1|summary
+apnic|*|ipv4|*|3|summary
+apnic|*|ipv6|*|1|summary
+apnic|AU|asn|173|1|20020801|allocated|A91BF1E7
+apnic|AU|ipv4|1.0.0.0|256|20110811|assigned|A91872ED
+apnic|CN|ipv4|1.0.1.0|768|20110414|allocated|A92E1062
+apnic|ZZ|ipv4|1.0.5.0|256||available||
+apnic|jp|ipv6|2001:dc0::|32|00000000|ALLOCATED|A91CBC82
+
+apnic|AU|ipv4|1.0.8.0|1024|20110412|allocated
+`
+
+func TestReader(t *testing.T) {
+	r := NewReader(strings.NewReader(delegated))
+
+	record, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, &Record{
+		Registry:    "apnic",
+		CountryCode: "AU",
+		Start:       net.ParseIP("1.0.0.0").To4(),
+		End:         net.ParseIP("1.0.0.255").To4(),
+		Date:        time.Date(2011, 8, 11, 0, 0, 0, 0, time.UTC),
+		Status:      "assigned",
+		OpaqueID:    "A91872ED",
+	}, record)
+	assert.Equal(t, mmdbtype.Map{
+		"country": mmdbtype.Map{"iso_code": mmdbtype.String("AU")},
+		"allocation": mmdbtype.Map{
+			"registry":  mmdbtype.String("apnic"),
+			"status":    mmdbtype.String("assigned"),
+			"date":      mmdbtype.String("2011-08-11"),
+			"opaque_id": mmdbtype.String("A91872ED"),
+		},
+	}, record.Data())
+
+	// 768 addresses are not a single network.
+	record, err = r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, net.ParseIP("1.0.1.0").To4(), record.Start)
+	assert.Equal(t, net.ParseIP("1.0.3.255").To4(), record.End)
+
+	record, err = r.Read()
+	require.NoError(t, err)
+	assert.Nil(t, record.Start)
+	assert.Equal(t, "2001:dc0::/32", record.Network.String())
+	assert.Equal(t, "JP", record.CountryCode)
+	assert.Equal(t, "allocated", record.Status)
+	assert.True(t, record.Date.IsZero())
+	assert.Equal(t, mmdbtype.Map{
+		"country": mmdbtype.Map{"iso_code": mmdbtype.String("JP")},
+		"allocation": mmdbtype.Map{
+			"registry":  mmdbtype.String("apnic"),
+			"status":    mmdbtype.String("allocated"),
+			"opaque_id": mmdbtype.String("A91CBC82"),
+		},
+	}, record.Data())
+
+	// Files in the standard format have no opaque IDs.
+	record, err = r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, net.ParseIP("1.0.11.255").To4(), record.End)
+	assert.Empty(t, record.OpaqueID)
+
+	_, err = r.Read()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReaderStatuses(t *testing.T) {
+	r := NewReader(strings.NewReader(delegated))
+	r.Statuses = []string{"available"}
+
+	record, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, net.ParseIP("1.0.5.0").To4(), record.Start)
+	assert.Empty(t, record.CountryCode)
+	assert.Equal(t, mmdbtype.Map{
+		"allocation": mmdbtype.Map{
+			"registry": mmdbtype.String("apnic"),
+			"status":   mmdbtype.String("available"),
+		},
+	}, record.Data())
+
+	_, err = r.Read()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := map[string]string{
+		"apnic|AU|ipv4|1.0.0.0|256":                      "line 1: expected at least 7 fields but found 5",
+		"apnic|AU|ipv5|1.0.0.0|256|20110811|assigned":    `line 1: unknown type "ipv5"`,
+		"apnic|AU|ipv4|1.0.0.0|256|2011-08-11|assigned":  `line 1: invalid date "2011-08-11"`,
+		"apnic|AU|ipv4|2001:dc0::|256|20110811|assigned": `line 1: invalid IPv4 address "2001:dc0::"`,
+		"apnic|AU|ipv4|1.0.0.0|0|20110811|assigned":      `line 1: invalid number of addresses "0"`,
+		"apnic|AU|ipv4|1.0.0.0|x|20110811|assigned":      `line 1: invalid number of addresses "x"`,
+		"apnic|AU|ipv4|255.255.255.0|257|20110811|assigned": "line 1: 257 addresses starting at 255.255.255.0 " +
+			"is past the end of the IPv4 space",
+		"apnic|AU|ipv6|1.0.0.0|32|20110811|assigned":     `line 1: invalid IPv6 address "1.0.0.0"`,
+		"apnic|AU|ipv6|2001:dc0::|129|20110811|assigned": `line 1: invalid prefix length "129"`,
+		"apnic|AU|ipv6|2001:dc0::1|32|20110811|assigned": "line 1: 2001:dc0::1 is not the first address of a /32",
+	}
+	for input, expected := range tests {
+		_, err := NewReader(strings.NewReader(input)).Read()
+		assert.EqualError(t, err, expected, input)
+	}
+
+	// The last address may be included.
+	r := NewReader(strings.NewReader("apnic|AU|ipv4|255.255.255.0|256|20110811|assigned"))
+	record, err := r.Read()
+	require.NoError(t, err)
+	assert.Equal(t, net.ParseIP("255.255.255.255").To4(), record.End)
+}
+
+func TestInsert(t *testing.T) {
+	tree, err := mmdbwriter.New(mmdbwriter.Options{})
+	require.NoError(t, err)
+
+	n, err := Insert(tree, NewReader(strings.NewReader(delegated)), inserter.ReplaceWith)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	var networks []string
+	require.NoError(t, tree.Networks(func(network *net.IPNet, _ mmdbtype.DataType) error {
+		networks = append(networks, network.String())
+		return nil
+	}))
+	assert.Equal(t, []string{
+		"1.0.0.0/24",
+		"1.0.1.0/24",
+		"1.0.2.0/23",
+		"1.0.8.0/22",
+		"2001:dc0::/32",
+	}, networks)
+
+	_, v := tree.Get(net.ParseIP("1.0.2.1"))
+	assert.Equal(t, mmdbtype.String("CN"), v.(mmdbtype.Map)["country"].(mmdbtype.Map)["iso_code"])
+}
@@ -0,0 +1,249 @@
+package mmdbwriter
+
+import (
+	"errors"
+	"net"
+
+	"github.com/maxmind/mmdbwriter/inserter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+// ErrTransactionDone is returned by any operation performed on a
+// Transaction that has already been committed or rolled back.
+var ErrTransactionDone = errors.New("transaction has already been committed or rolled back")
+
+// Transaction is a batch of inserts that is applied to a Tree atomically.
+// Either all of the inserts are kept by calling Commit or none of them are
+// by calling Rollback.
+//
+// While a transaction is open, any inserts made directly on the Tree are
+// also part of the transaction. A Transaction is not safe to use from
+// multiple threads.
+type Transaction struct {
+	tree    *Tree
+	journal *journal
+}
+
+// Begin starts a transaction on the tree. Only one transaction may be open
+// on a tree at a time.
+func (t *Tree) Begin() (*Transaction, error) {
+	if t.journal != nil {
+		return nil, errors.New("a transaction is already open on this tree")
+	}
+	t.journal = &journal{nodes: &t.nodes, dataMap: t.dataMap}
+	return &Transaction{tree: t, journal: t.journal}, nil
+}
+
+// Insert is the same as Tree.Insert, except that the insert is part of the
+// transaction.
+func (tx *Transaction) Insert(network *net.IPNet, value mmdbtype.DataType) error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	return tx.tree.Insert(network, value)
+}
+
+// InsertFunc is the same as Tree.InsertFunc, except that the insert is part
+// of the transaction.
+func (tx *Transaction) InsertFunc(
+	network *net.IPNet,
+	inserterFunc inserter.Func,
+) error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	return tx.tree.InsertFunc(network, inserterFunc)
+}
+
+// InsertRange is the same as Tree.InsertRange, except that the insert is
+// part of the transaction.
+func (tx *Transaction) InsertRange(
+	start net.IP,
+	end net.IP,
+	value mmdbtype.DataType,
+) error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	return tx.tree.InsertRange(start, end, value)
+}
+
+// InsertRangeFunc is the same as Tree.InsertRangeFunc, except that the
+// insert is part of the transaction.
+func (tx *Transaction) InsertRangeFunc(
+	start net.IP,
+	end net.IP,
+	inserterFunc inserter.Func,
+) error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	return tx.tree.InsertRangeFunc(start, end, inserterFunc)
+}
+
+// Commit keeps all of the changes made during the transaction.
+func (tx *Transaction) Commit() error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	tx.journal.commit()
+	tx.tree.journal = nil
+	return nil
+}
+
+// Rollback undoes all of the changes made during the transaction, including
+// those made by an insert that returned an error partway through.
+func (tx *Transaction) Rollback() error {
+	if err := tx.check(); err != nil {
+		return err
+	}
+	tx.journal.undo()
+	tx.tree.journal = nil
+	// The tree must be finalized again as the rollback may have undone
+	// changes made after the last finalize.
+	tx.tree.nodeCount = 0
+	return nil
+}
+
+func (tx *Transaction) check() error {
+	if tx.tree.journal != tx.journal {
+		return ErrTransactionDone
+	}
+	return nil
+}
+
+type journalEntryType byte
+
+const (
+	journalRecord journalEntryType = iota
+	journalNodeAlloc
+	journalValueStore
+	journalValueRemove
+)
+
+type journalEntry struct {
+	typ journalEntryType
+	pos byte
+	// index is the index of the node or value.
+	index uint32
+	// old is the previous record for journalRecord entries.
+	old record
+	// changedIndex is set on journalValueStore entries when a new value was
+	// added to the dataMap and on journalValueRemove entries when a value
+	// was removed from it.
+	changedIndex bool
+}
+
+// journal records the changes made to the nodes and the dataMap of a tree
+// so that they may be undone.
+//
+// While a journal is in use, nodes and values are always appended rather
+// than reusing free slots, and nodes and values that are no longer used
+// are only released on commit. This way, undoing an allocation is a matter
+// of truncating a slice and the contents of released nodes and values are
+// still available if the release is undone.
+type journal struct {
+	nodes   *nodeArena
+	dataMap *dataMap
+	entries []journalEntry
+
+	releasedNodes  []uint32
+	releasedValues []uint32
+}
+
+// changedRecord records the previous value of the record at pos in node n.
+// All of the journal methods are safe to call on a nil journal.
+func (j *journal) changedRecord(n uint32, pos byte, old record) {
+	if j == nil {
+		return
+	}
+	j.entries = append(j.entries, journalEntry{
+		typ:   journalRecord,
+		pos:   pos,
+		index: n,
+		old:   old,
+	})
+}
+
+func (j *journal) allocatedNode(idx uint32) {
+	if j == nil {
+		return
+	}
+	j.entries = append(j.entries, journalEntry{typ: journalNodeAlloc, index: idx})
+}
+
+func (j *journal) releasedNode(idx uint32) {
+	if j == nil {
+		return
+	}
+	j.releasedNodes = append(j.releasedNodes, idx)
+}
+
+func (j *journal) storedValue(idx uint32, added bool) {
+	if j == nil {
+		return
+	}
+	j.entries = append(j.entries, journalEntry{
+		typ:          journalValueStore,
+		index:        idx,
+		changedIndex: added,
+	})
+}
+
+func (j *journal) removedValue(idx uint32, removed bool) {
+	if j == nil {
+		return
+	}
+	j.entries = append(j.entries, journalEntry{
+		typ:          journalValueRemove,
+		index:        idx,
+		changedIndex: removed,
+	})
+	if removed {
+		j.releasedValues = append(j.releasedValues, idx)
+	}
+}
+
+// commit releases the nodes and values that are no longer used.
+func (j *journal) commit() {
+	j.nodes.free = append(j.nodes.free, j.releasedNodes...)
+	for _, idx := range j.releasedValues {
+		j.dataMap.release(idx)
+	}
+	j.entries = nil
+	j.releasedNodes = nil
+	j.releasedValues = nil
+}
+
+// undo reverts the changes in the reverse order that they were made.
+func (j *journal) undo() {
+	for i := len(j.entries) - 1; i >= 0; i-- {
+		e := j.entries[i]
+		switch e.typ {
+		case journalRecord:
+			j.nodes.nodes[e.index].children[e.pos] = e.old
+		case journalNodeAlloc:
+			// Allocations are always appended, so this is the last node.
+			j.nodes.nodes = j.nodes.nodes[:e.index]
+		case journalValueStore:
+			if e.changedIndex {
+				// As with nodes, this is the last value.
+				j.dataMap.removeIndex(e.index)
+				j.dataMap.keyWriter.forget(j.dataMap.values[e.index].data)
+				j.dataMap.values[e.index] = dataMapValue{}
+				j.dataMap.values = j.dataMap.values[:e.index]
+				continue
+			}
+			j.dataMap.values[e.index].refCount--
+		case journalValueRemove:
+			j.dataMap.values[e.index].refCount++
+			if e.changedIndex {
+				j.dataMap.addIndex(e.index)
+			}
+		default:
+		}
+	}
+	j.entries = nil
+	j.releasedNodes = nil
+	j.releasedValues = nil
+}
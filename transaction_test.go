@@ -0,0 +1,124 @@
+package mmdbwriter
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransaction(t *testing.T) {
+	newTree := func(t *testing.T) *Tree {
+		tree, err := New(
+			Options{
+				DatabaseType: "mmdbwriter-test",
+				Description:  map[string]string{"en": "Test database"},
+			},
+		)
+		require.NoError(t, err)
+
+		for _, network := range []string{"1.1.1.0/24", "1.1.2.0/24"} {
+			_, ipNet, err := net.ParseCIDR(network)
+			require.NoError(t, err)
+			require.NoError(t, tree.Insert(ipNet, mmdbtype.Map{"network": mmdbtype.String(network)}))
+		}
+		return tree
+	}
+
+	writeTree := func(t *testing.T, tree *Tree) []byte {
+		buf := &bytes.Buffer{}
+		_, err := tree.WriteTo(buf)
+		require.NoError(t, err)
+		return buf.Bytes()
+	}
+
+	t.Run("rollback after reserved network error", func(t *testing.T) {
+		tree := newTree(t)
+		before := writeTree(t, tree)
+
+		tx, err := tree.Begin()
+		require.NoError(t, err)
+
+		_, ipNet, err := net.ParseCIDR("1.1.0.0/16")
+		require.NoError(t, err)
+		require.NoError(t, tx.Insert(ipNet, mmdbtype.String("new")))
+
+		_, ipNet, err = net.ParseCIDR("10.0.0.0/24")
+		require.NoError(t, err)
+		assert.EqualError(
+			t,
+			tx.Insert(ipNet, mmdbtype.String("reserved")),
+			"attempt to insert ::a00:0/120, which is in a reserved network",
+		)
+
+		require.NoError(t, tx.Rollback())
+
+		_, value := tree.Get(net.ParseIP("1.1.3.1"))
+		assert.Nil(t, value)
+
+		assert.Equal(t, before, writeTree(t, tree))
+		assert.Equal(t, 2, tree.dataMap.len())
+	})
+
+	t.Run("rollback after inserter error partway through", func(t *testing.T) {
+		tree := newTree(t)
+		before := writeTree(t, tree)
+
+		tx, err := tree.Begin()
+		require.NoError(t, err)
+
+		// The first record is updated before the function fails on the
+		// second record.
+		calls := 0
+		_, ipNet, err := net.ParseCIDR("1.1.0.0/22")
+		require.NoError(t, err)
+		err = tx.InsertFunc(
+			ipNet,
+			func(v mmdbtype.DataType) (mmdbtype.DataType, error) {
+				calls++
+				if calls > 1 {
+					return nil, errors.New("inserter failed")
+				}
+				return mmdbtype.String("updated"), nil
+			},
+		)
+		require.EqualError(t, err, "inserter failed")
+
+		require.NoError(t, tx.Rollback())
+
+		assert.Equal(t, before, writeTree(t, tree))
+		assert.Equal(t, 2, tree.dataMap.len())
+		for _, idx := range tree.dataMap.index {
+			assert.Equal(t, uint32(1), tree.dataMap.get(idx).refCount)
+		}
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		tree := newTree(t)
+
+		tx, err := tree.Begin()
+		require.NoError(t, err)
+
+		_, err = tree.Begin()
+		assert.EqualError(t, err, "a transaction is already open on this tree")
+
+		_, ipNet, err := net.ParseCIDR("1.1.1.0/25")
+		require.NoError(t, err)
+		require.NoError(t, tx.Insert(ipNet, mmdbtype.String("new")))
+
+		require.NoError(t, tx.Commit())
+
+		assert.ErrorIs(t, tx.Rollback(), ErrTransactionDone)
+		assert.ErrorIs(t, tx.Insert(ipNet, mmdbtype.String("new")), ErrTransactionDone)
+
+		_, value := tree.Get(net.ParseIP("1.1.1.1"))
+		assert.Equal(t, mmdbtype.String("new"), value)
+
+		_, err = tree.Begin()
+		assert.NoError(t, err)
+	})
+}
@@ -4,6 +4,7 @@ package mmdbwriter
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -74,10 +75,64 @@ type Options struct {
 	// use should primarily be limited to existing database types.
 	DisableMetadataPointers bool
 
+	// ExtraMetadata contains additional entries to add to the metadata
+	// section of the database, e.g., the versions of the source data or
+	// license terms. It may not contain any of the keys defined by the
+	// MaxMind DB spec, such as "database_type" or "node_count".
+	//
+	// When using Load, any entries in the source database's metadata that
+	// are not defined by the spec are preserved unless they are overridden
+	// here.
+	ExtraMetadata mmdbtype.Map
+
 	// Inserter is the insert function used when calling `Insert`. It defaults
 	// to `inserter.ReplaceWith`, which replaces any conflicting old value
 	// entirely with the new.
 	Inserter inserter.FuncGenerator
+
+	// Reproducible requires BuildEpoch to be set explicitly rather than
+	// defaulting to the current time. The build epoch is the only part of
+	// the output that otherwise differs between builds of the same
+	// networks and data, so setting this ensures that it is not left out
+	// by mistake.
+	Reproducible bool
+
+	// MapKeyOrder determines the order in which the keys of each map in the
+	// data section are written. Putting the keys most likely to be
+	// accessed first, e.g., with mmdbtype.PriorityKeyOrder("country"),
+	// allows readers that only decode part of a record to stop earlier. By
+	// default, keys are written in order by key value. The metadata is
+	// always written in order by key value.
+	MapKeyOrder mmdbtype.KeyOrder
+
+	// OptimizeDataSection reorders the data section so that the sub-values
+	// shared by the most records, e.g., identical "country" or "names"
+	// maps, are written near its start, where they may be referred to with
+	// the shortest pointers. This requires an extra pass over the data when
+	// writing, but it may reduce the size of databases with many records
+	// that share sub-values. The output remains reproducible.
+	OptimizeDataSection bool
+
+	// WriteConcurrency is the number of goroutines used to serialize the
+	// data section when writing the tree, e.g., runtime.GOMAXPROCS(0). The
+	// output is identical to that of writing with a single goroutine, which
+	// is the default.
+	WriteConcurrency int
+
+	// IncrementalWrites keeps the serialized form of each value between
+	// writes so that writing the tree again after changing some of its
+	// networks only serializes the values that were added. Similarly, the
+	// search tree is only rebuilt when networks were split or merged. This
+	// is useful when writing the same tree many times with small changes,
+	// but it uses more memory. The output is the same as without this
+	// option.
+	IncrementalWrites bool
+
+	// Progress, if set, is called periodically by Load and WriteTo, and
+	// their context-aware variants, to report how far they have gotten. It
+	// is always called once when they finish successfully.
+	Progress ProgressFunc
+
 	// Only use English name
 	OnlyEn        bool
 	DelRegCountry bool
@@ -90,14 +145,21 @@ type Tree struct {
 	dataMap                 *dataMap
 	description             map[string]string
 	disableMetadataPointers bool
+	extraMetadata           mmdbtype.Map
 	ipVersion               int
 	languages               []string
+	mapKeyOrder             mmdbtype.KeyOrder
+	nodes                   nodeArena
+	optimizeDataSection     bool
 	recordSize              int
-	root                    *node
 	treeDepth               int
+	writeConcurrency        int
 	// This is set when the tree is finalized
 	nodeCount       int
 	inserterFuncGen inserter.FuncGenerator
+	progress        ProgressFunc
+	// This is set while a transaction is open
+	journal *journal
 }
 
 // New creates a new Tree.
@@ -109,19 +171,38 @@ func New(opts Options) (*Tree, error) {
 		description:             map[string]string{},
 		disableMetadataPointers: opts.DisableMetadataPointers,
 		ipVersion:               6,
+		mapKeyOrder:             opts.MapKeyOrder,
+		nodes:                   nodeArena{nodes: []node{{}}},
+		optimizeDataSection:     opts.OptimizeDataSection,
 		recordSize:              28,
-		root:                    &node{},
 		inserterFuncGen:         inserter.ReplaceWith,
+		progress:                opts.Progress,
+		writeConcurrency:        opts.WriteConcurrency,
 	}
 
 	if opts.BuildEpoch != 0 {
 		tree.buildEpoch = opts.BuildEpoch
+	} else if opts.Reproducible {
+		return nil, errors.New("BuildEpoch must be set when Reproducible is enabled")
 	}
 
 	if opts.Description != nil {
 		tree.description = opts.Description
 	}
 
+	if opts.IncrementalWrites {
+		tree.dataMap.encodings = &encodingCache{}
+	}
+
+	if err := validateExtraMetadata(opts.ExtraMetadata); err != nil {
+		return nil, err
+	}
+	if opts.ExtraMetadata != nil {
+		// The map is copied so that later changes by the caller are not
+		// written without being validated.
+		tree.extraMetadata = opts.ExtraMetadata.Copy().(mmdbtype.Map)
+	}
+
 	if opts.IPVersion != 0 {
 		tree.ipVersion = opts.IPVersion
 	}
@@ -163,34 +244,134 @@ func New(opts Options) (*Tree, error) {
 	return tree, nil
 }
 
+// TrimNames removes the names in languages other than English from val, a
+// map with a "names" map such as the country of a GeoIP2 record.
+//
+// Values that are not maps, maps without a "names" map, and names without
+// an English name are left unchanged. TrimNames used to panic on the first
+// two, e.g., when passed the subdivisions of a record rather than one of
+// them.
 func TrimNames(val interface{}) {
-	if val == nil {
+	v, ok := val.(mmdbtype.Map)
+	if !ok {
 		return
 	}
+	trimNames(v, func(path []mmdbtype.PathElem) bool {
+		return len(path) == 1 && path[0].Key == "names"
+	})
+}
 
-	v := val.(mmdbtype.Map)
+// TrimRVNames removes the names in languages other than English from the
+// continent, country, city, registered_country, and subdivisions of a
+// GeoIP2 or GeoLite2 record. Names without an English version are kept.
+func TrimRVNames(rv mmdbtype.Map) {
+	trimNames(rv, isLocationNames)
+}
 
-	names := v["names"].(mmdbtype.Map)
-	if en, ok := names["en"]; ok {
-		new_names := mmdbtype.Map{"en": en}
-		v["names"] = new_names
+// trimNames replaces each names map in m whose path isNames reports true
+// for with a map holding only the English name. Only the entries of m are
+// replaced; the values nested in m are never modified, as they may be
+// shared, e.g., by the records of a loaded database.
+func trimNames(m mmdbtype.Map, isNames func(path []mmdbtype.PathElem) bool) {
+	trimmed, err := mmdbtype.Transform(
+		m,
+		func(path []mmdbtype.PathElem, v mmdbtype.DataType) (mmdbtype.DataType, error) {
+			if !isNames(path) {
+				return v, nil
+			}
+			if names, ok := v.(mmdbtype.Map); ok {
+				if en, ok := names["en"]; ok {
+					return mmdbtype.Map{"en": en}, nil
+				}
+			}
+			return v, nil
+		},
+	)
+	// The function above never returns an error, but should Transform
+	// fail, m is left unchanged.
+	trimmedMap, ok := trimmed.(mmdbtype.Map)
+	if err != nil || !ok {
+		return
+	}
+	for k, v := range trimmedMap {
+		m[k] = v
 	}
 }
 
-func TrimRVNames(rv mmdbtype.Map) {
-	TrimNames(rv["continent"])
-	TrimNames(rv["country"])
-	TrimNames(rv["city"])
-	TrimNames(rv["registered_country"])
-	if subdivisions, ok := rv["subdivisions"]; ok {
-		for _, sd := range subdivisions.(mmdbtype.Slice) {
-			TrimNames(sd)
+// isLocationNames reports whether path is the path to the names of one of
+// the locations in a GeoIP2 record.
+func isLocationNames(path []mmdbtype.PathElem) bool {
+	switch len(path) {
+	case 2:
+		switch path[0].Key {
+		case "continent", "country", "city", "registered_country":
+			return path[1].Key == "names"
 		}
+	case 3:
+		return path[0].Key == "subdivisions" && path[1].IsIndex() && path[2].Key == "names"
 	}
+	return false
+}
+
+// Clone returns a deep copy of the tree. The copy shares no nodes or
+// data map state with the original, so either tree may be modified or
+// written without affecting the other. As the nodes are stored in a single
+// slice, copying them is fast even for large trees.
+//
+// The mmdbtype.DataType values stored in the tree are shared between the
+// copies rather than copied. This is safe as stored values must never be
+// modified in place; see InsertFunc.
+//
+// Cloning is considerably cheaper than loading a database again as the
+// values do not need to be deserialized or deduplicated a second time.
+//
+// This is not safe to call while the tree is being modified from another
+// thread.
+func (t *Tree) Clone() *Tree {
+	description := make(map[string]string, len(t.description))
+	for k, v := range t.description {
+		description[k] = v
+	}
+
+	var languages []string
+	if t.languages != nil {
+		languages = make([]string, len(t.languages))
+		copy(languages, t.languages)
+	}
+
+	clone := *t
+	clone.dataMap = t.dataMap.clone()
+	clone.description = description
+	clone.languages = languages
+	clone.nodes = nodeArena{
+		nodes:   make([]node, len(t.nodes.nodes)),
+		free:    make([]uint32, len(t.nodes.free)),
+		changed: t.nodes.changed,
+	}
+	copy(clone.nodes.nodes, t.nodes.nodes)
+	copy(clone.nodes.free, t.nodes.free)
+
+	// The clone is not part of the open transaction, if any, so the nodes
+	// and values released during it may be reused immediately.
+	clone.journal = nil
+	if t.journal != nil {
+		clone.nodes.free = append(clone.nodes.free, t.journal.releasedNodes...)
+		for _, idx := range t.journal.releasedValues {
+			clone.dataMap.release(idx)
+		}
+	}
+
+	return &clone
 }
 
 // Load an existing database into the writer.
 func Load(path string, opts Options) (*Tree, error) {
+	return LoadContext(context.Background(), path, opts)
+}
+
+// LoadContext is the same as Load, except that loading stops and the
+// context's error is returned if the context is canceled.
+func LoadContext(ctx context.Context, path string, opts Options) (*Tree, error) {
 	db, err := maxminddb.Open(path)
 	if err != nil {
 		return nil, err
@@ -218,6 +399,15 @@ func Load(path string, opts Options) (*Tree, error) {
 		opts.RecordSize = int(metadata.RecordSize)
 	}
 
+	extraMetadata, err := readExtraMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range opts.ExtraMetadata {
+		extraMetadata[k] = v
+	}
+	opts.ExtraMetadata = extraMetadata
+
 	tree, err := New(opts)
 	if err != nil {
 		return nil, err
@@ -230,6 +420,8 @@ func Load(path string, opts Options) (*Tree, error) {
 		networkOpts = append(networkOpts, maxminddb.SkipAliasedNetworks)
 	}
 
+	tracker := newProgressTracker(ctx, opts.Progress)
+
 	networks := db.Networks(networkOpts...)
 	for networks.Next() {
 		var network *net.IPNet
@@ -240,23 +432,30 @@ func Load(path string, opts Options) (*Tree, error) {
 			return nil, err
 		}
 
-		rv := dser.rv.(mmdbtype.Map)
-		if opts.DelRegCountry {
-			delete(rv, "registered_country")
-		}
+		if rv, ok := dser.rv.(mmdbtype.Map); ok {
+			if opts.DelRegCountry {
+				delete(rv, "registered_country")
+			}
 
-		if opts.OnlyEn {
-			TrimRVNames(rv)
+			if opts.OnlyEn {
+				TrimRVNames(rv)
+			}
 		}
 
 		err = tree.Insert(network, dser.rv)
 		if err != nil {
 			return nil, err
 		}
+
+		tracker.current.NetworksLoaded++
+		if err := tracker.step(); err != nil {
+			return nil, err
+		}
 	}
 	if err := networks.Err(); err != nil {
 		return nil, err
 	}
+	tracker.done()
 	return tree, nil
 }
 
@@ -287,14 +486,14 @@ func (t *Tree) InsertFunc(
 	network *net.IPNet,
 	inserterFunc inserter.Func,
 ) error {
-	return t.insert(network, recordTypeData, inserterFunc, nil)
+	return t.insert(network, recordTypeData, inserterFunc, 0)
 }
 
 func (t *Tree) insert(
 	network *net.IPNet,
 	recordType recordType,
 	inserterFunc inserter.Func,
-	node *node,
+	node uint32,
 ) error {
 	// We set this to 0 so that the tree must be finalized again.
 	t.nodeCount = 0
@@ -307,18 +506,18 @@ func (t *Tree) insert(
 		prefixLen += 96
 	}
 
-	return t.root.insert(
-		insertRecord{
-			ip:           ip,
-			prefixLen:    prefixLen,
-			recordType:   recordType,
-			inserter:     inserterFunc,
-			insertedNode: node,
+	iRec := &insertRecord{
+		ip:           ip,
+		prefixLen:    prefixLen,
+		recordType:   recordType,
+		inserter:     inserterFunc,
+		insertedNode: node,
 
-			dataMap: t.dataMap,
-		},
-		0,
-	)
+		nodes:   &t.nodes,
+		dataMap: t.dataMap,
+		journal: t.journal,
+	}
+	return iRec.insertIntoNode(rootNode, 0)
 }
 
 // InsertRange is the same as Insert, except it will insert all subnets within
@@ -338,7 +537,7 @@ func (t *Tree) InsertRangeFunc(
 	end net.IP,
 	inserterFunc inserter.Func,
 ) error {
-	return t.insertRange(start, end, recordTypeData, inserterFunc, nil)
+	return t.insertRange(start, end, recordTypeData, inserterFunc, 0)
 }
 
 func (t *Tree) insertRange(
@@ -346,7 +545,7 @@ func (t *Tree) insertRange(
 	end net.IP,
 	recordType recordType,
 	inserterFunc inserter.Func,
-	node *node,
+	node uint32,
 ) error {
 	startNetIP, ok := netipx.FromStdIP(start)
 	if !ok {
@@ -375,7 +574,7 @@ func (t *Tree) insertStringNetwork(
 	network string,
 	recordType recordType,
 	inserterFunc inserter.Func,
-	node *node,
+	node uint32,
 ) error {
 	_, ipnet, err := net.ParseCIDR(network)
 	if err != nil {
@@ -396,7 +595,10 @@ func (t *Tree) insertIPv4Aliases() error {
 		return fmt.Errorf("parsing IPv4 root: %w", err)
 	}
 
-	ipv4RootNode := &node{}
+	ipv4RootNode, err := t.nodes.alloc(node{}, t.journal)
+	if err != nil {
+		return err
+	}
 
 	// Make ::/96, the IPv4 root, a fixed node.
 	err = t.insert(ipv4Root, recordTypeFixedNode, nil, ipv4RootNode)
@@ -421,7 +623,7 @@ func (t *Tree) insertReservedNetworks() error {
 	}
 
 	for _, network := range networks {
-		err := t.insertStringNetwork(network, recordTypeReserved, nil, nil)
+		err := t.insertStringNetwork(network, recordTypeReserved, nil, 0)
 		if err != nil {
 			return err
 		}
@@ -430,7 +632,8 @@ func (t *Tree) insertReservedNetworks() error {
 }
 
 // Get the value for the given IP address from the tree. If the nil interface
-// is returned, that means the tree does not have a value for the IP.
+// is returned, that means the tree does not have a value for the IP. Looking
+// up an IPv6 address in an IPv4 tree returns ::/0 and the nil interface.
 func (t *Tree) Get(ip net.IP) (*net.IPNet, mmdbtype.DataType) {
 	lookupIP := ip
 
@@ -445,22 +648,35 @@ func (t *Tree) Get(ip net.IP) (*net.IPNet, mmdbtype.DataType) {
 		if ipv4 := ip.To4(); ipv4 != nil {
 			lookupIP = ipV4ToV6(ipv4)
 		}
+	} else {
+		ipv4 := ip.To4()
+		if ipv4 == nil {
+			// An IPv6 address is outside of every network in an IPv4 tree.
+			return &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}, nil
+		}
+		// The bits of the IPv4 tree are those of the 4 byte form.
+		ip = ipv4
+		lookupIP = ipv4
 	}
 
-	prefixLen, r := t.root.get(lookupIP, 0)
-
-	// This is so that if you look up an IPv4 address in a database that has
-	// an IPv4 subtree, you will get back an IPv4 network. This matches what
-	// github.com/oschwald/maxminddb-golang does.
-	if prefixLen >= 96 && len(ip) == 4 {
-		prefixLen -= 96
-	}
+	prefixLen, r := t.nodes.get(lookupIP, rootNode, 0)
 
 	mask := net.CIDRMask(prefixLen, t.treeDepth)
+	if len(ip) == 4 && t.treeDepth == 128 {
+		if prefixLen >= 96 {
+			// This is so that if you look up an IPv4 address in a database
+			// that has an IPv4 subtree, you will get back an IPv4 network.
+			// This matches what github.com/oschwald/maxminddb-golang does.
+			mask = net.CIDRMask(prefixLen-96, 32)
+		} else {
+			// The network contains more than the IPv4 subtree, e.g., ::/64.
+			ip = lookupIP
+		}
+	}
 
 	var value mmdbtype.DataType
-	if r.recordType == recordTypeData {
-		value = r.value.data
+	if r.recordType() == recordTypeData {
+		value = t.dataMap.get(r.index()).data
 	}
 
 	return &net.IPNet{
@@ -469,15 +685,36 @@ func (t *Tree) Get(ip net.IP) (*net.IPNet, mmdbtype.DataType) {
 	}, value
 }
 
-// finalize prepares the tree for writing. It is not threadsafe.
-func (t *Tree) finalize() {
-	t.nodeCount = t.root.finalize(0)
+// Finalize prepares the tree for writing by numbering its nodes. WriteTo
+// calls it if the tree has changed since it was last finalized, so calling
+// it directly is only useful to measure or control when the work is done.
+// Finalizing a tree whose networks were only assigned different values is
+// cheap. It is not threadsafe.
+func (t *Tree) Finalize() error {
+	if t.journal != nil {
+		// Finalizing moves the nodes, which would invalidate the journal.
+		return errors.New("cannot finalize a tree with an open transaction")
+	}
+	if t.nodeCount == 0 {
+		t.nodeCount = t.nodes.compact()
+	}
+	return nil
 }
 
 // WriteTo writes the tree to the provided Writer.
 func (t *Tree) WriteTo(w io.Writer) (int64, error) {
-	if t.nodeCount == 0 {
-		t.finalize()
+	return t.WriteToContext(context.Background(), w)
+}
+
+// WriteToContext is the same as WriteTo, except that writing stops and the
+// context's error is returned if the context is canceled. The data already
+// written to w will not be a valid database in that case.
+func (t *Tree) WriteToContext(ctx context.Context, w io.Writer) (int64, error) {
+	if t.journal != nil {
+		return 0, errors.New("cannot write a tree with an open transaction")
+	}
+	if err := t.Finalize(); err != nil {
+		return 0, err
 	}
 
 	buf := bufio.NewWriter(w)
@@ -491,19 +728,32 @@ func (t *Tree) WriteTo(w io.Writer) (int64, error) {
 
 	usePointers := true
 	dataWriter := newDataWriter(t.dataMap, usePointers)
+	dataWriter.keyOrder = t.mapKeyOrder
 
-	nodeCount, numBytes, err := t.writeNode(buf, t.root, dataWriter, recordBuf)
+	// The data section is written before the search tree so that we know
+	// the offset of each value, and thus whether the database fits, before
+	// anything is written to w.
+	offsets, maxOffset, err := t.writeData(ctx, dataWriter)
 	if err != nil {
-		return numBytes, err
+		return 0, err
 	}
-	if nodeCount != t.nodeCount {
-		// This should only happen if there is a programming bug
-		// in this library.
-		return numBytes, fmt.Errorf(
-			"number of nodes written (%d) doesn't match number expected (%d)",
-			nodeCount,
-			t.nodeCount,
-		)
+
+	metadataWriter := newDataWriter(dataWriter.dataMap, !t.disableMetadataPointers)
+	_, err = t.writeMetadata(metadataWriter)
+	if err != nil {
+		return 0, fmt.Errorf("writing metadata: %w", err)
+	}
+
+	report := t.newCapacityReport(dataWriter.Len(), maxOffset, metadataWriter.Len())
+	if report.Overflow > 0 {
+		return 0, &CapacityError{Report: report}
+	}
+
+	tracker := newProgressTracker(ctx, t.progress)
+
+	numBytes, err := t.writeNodes(buf, offsets, recordBuf, tracker)
+	if err != nil {
+		return numBytes, err
 	}
 
 	nb, err := buf.Write(dataSectionSeparator)
@@ -524,12 +774,6 @@ func (t *Tree) WriteTo(w io.Writer) (int64, error) {
 		return numBytes, fmt.Errorf("writing metadata start marker: %w", err)
 	}
 
-	metadataWriter := newDataWriter(dataWriter.dataMap, !t.disableMetadataPointers)
-	_, err = t.writeMetadata(metadataWriter)
-	if err != nil {
-		return numBytes, fmt.Errorf("writing metadata: %w", err)
-	}
-
 	nb64, err = metadataWriter.WriteTo(buf)
 	numBytes += nb64
 	if err != nil {
@@ -541,73 +785,171 @@ func (t *Tree) WriteTo(w io.Writer) (int64, error) {
 		return numBytes, fmt.Errorf("flushing buffer to writer: %w", err)
 	}
 
+	tracker.current.BytesWritten = numBytes
+	tracker.done()
+
 	return numBytes, err
 }
 
-func (t *Tree) writeNode(
+func (t *Tree) writeNodes(
 	w io.Writer,
-	n *node,
-	dataWriter *dataWriter,
+	offsets []int,
 	recordBuf []byte,
-) (int, int64, error) {
-	err := t.copyNode(recordBuf, n, dataWriter)
+	tracker *progressTracker,
+) (int64, error) {
+	numBytes := int64(0)
+	// As the tree has been finalized, the nodes are in the order they are
+	// to be written.
+	for _, n := range t.nodes.nodes[:t.nodeCount] {
+		err := t.copyNode(recordBuf, n, offsets)
+		if err != nil {
+			return numBytes, err
+		}
+
+		nb, err := w.Write(recordBuf)
+		numBytes += int64(nb)
+		if err != nil {
+			return numBytes, fmt.Errorf("writing node: %w", err)
+		}
+
+		tracker.current.NodesWritten++
+		tracker.current.BytesWritten += int64(nb)
+		if err := tracker.step(); err != nil {
+			return numBytes, err
+		}
+	}
+
+	return numBytes, nil
+}
+
+// dataOrder returns the indexes of the values in the tree in the order in
+// which they are to be written to the data section. By default, this is
+// the order in which the search tree refers to them.
+func (t *Tree) dataOrder() ([]uint32, error) {
+	seen := make([]bool, len(t.dataMap.values))
+	var indexes []uint32
+	for _, n := range t.nodes.nodes[:t.nodeCount] {
+		for _, r := range n.children {
+			if r.recordType() != recordTypeData || seen[r.index()] {
+				continue
+			}
+			seen[r.index()] = true
+			indexes = append(indexes, r.index())
+		}
+	}
+
+	if !t.optimizeDataSection {
+		return indexes, nil
+	}
+
+	data := make([]mmdbtype.DataType, len(indexes))
+	for i, idx := range indexes {
+		data[i] = t.dataMap.get(idx).data
+	}
+	order, err := newLayoutPlanner(t.mapKeyOrder).plan(data)
 	if err != nil {
-		return 0, 0, err
+		return nil, fmt.Errorf("optimizing data section: %w", err)
+	}
+	ordered := make([]uint32, len(indexes))
+	for i, j := range order {
+		ordered[i] = indexes[j]
 	}
+	return ordered, nil
+}
 
-	numBytes := int64(0)
-	nb, err := w.Write(recordBuf)
-	numBytes += int64(nb)
-	nodesWritten := 1
+// writeData writes the values in the tree to the dataWriter. It returns
+// the offset of each value, indexed by its index in the dataMap, and the
+// largest offset.
+func (t *Tree) writeData(ctx context.Context, dataWriter *dataWriter) ([]int, int, error) {
+	indexes, err := t.dataOrder()
 	if err != nil {
-		return nodesWritten, numBytes, fmt.Errorf("writing node: %w", err)
+		return nil, 0, err
 	}
 
-	for i := 0; i < 2; i++ {
-		child := n.children[i]
-		if child.recordType != recordTypeNode && child.recordType != recordTypeFixedNode {
-			continue
+	offsets := make([]int, len(t.dataMap.values))
+	maxOffset := 0
+	setOffset := func(idx uint32, offset int) {
+		offsets[idx] = offset
+		if offset > maxOffset {
+			maxOffset = offset
 		}
-		addedNodes, addedBytes, err := t.writeNode(
-			w,
-			n.children[i].node,
-			dataWriter,
-			recordBuf,
+	}
+
+	cache := t.dataMap.encodings
+	if t.writeConcurrency > 1 {
+		err := encodeConcurrently(
+			ctx,
+			t.dataMap,
+			indexes,
+			cache,
+			t.writeConcurrency,
+			t.mapKeyOrder,
+			func(b *encodedBatch) error {
+				for i, idx := range b.indexes {
+					offset, err := dataWriter.writeEncoded(t.dataMap.get(idx), b.encoded[i])
+					if err != nil {
+						return err
+					}
+					setOffset(idx, offset)
+				}
+				return nil
+			},
 		)
-		nodesWritten += addedNodes
-		numBytes += addedBytes
+		return offsets, maxOffset, err
+	}
+
+	var ew *encodingWriter
+	if cache != nil {
+		ew = newEncodingWriter(t.mapKeyOrder)
+	}
+	for i, idx := range indexes {
+		if i%progressInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		value := t.dataMap.get(idx)
+		if cache == nil {
+			offset, err := dataWriter.maybeWrite(value)
+			if err != nil {
+				return nil, 0, err
+			}
+			setOffset(idx, offset)
+			continue
+		}
+
+		encoded := cache.get(idx)
+		if encoded == nil {
+			encoded, err = ew.encode(value.data)
+			if err != nil {
+				return nil, 0, err
+			}
+			cache.set(idx, encoded)
+		}
+		offset, err := dataWriter.writeEncoded(value, encoded)
 		if err != nil {
-			return nodesWritten, numBytes, err
+			return nil, 0, err
 		}
+		setOffset(idx, offset)
 	}
-
-	return nodesWritten, numBytes, nil
+	return offsets, maxOffset, nil
 }
 
-func (t *Tree) recordValue(
-	r record,
-	dataWriter *dataWriter,
-) (int, error) {
-	switch r.recordType {
+func (t *Tree) recordValue(r record, offsets []int) int {
+	switch r.recordType() {
 	case recordTypeData:
-		offset, err := dataWriter.maybeWrite(r.value)
-		return t.nodeCount + len(dataSectionSeparator) + offset, err
+		return t.nodeCount + len(dataSectionSeparator) + offsets[r.index()]
 	case recordTypeEmpty, recordTypeReserved:
-		return t.nodeCount, nil
+		return t.nodeCount
 	default:
-		return r.node.nodeNum, nil
+		return int(r.index())
 	}
 }
 
-func (t *Tree) copyNode(buf []byte, n *node, dataWriter *dataWriter) error {
-	left, err := t.recordValue(n.children[0], dataWriter)
-	if err != nil {
-		return err
-	}
-	right, err := t.recordValue(n.children[1], dataWriter)
-	if err != nil {
-		return err
-	}
+func (t *Tree) copyNode(buf []byte, n node, offsets []int) error {
+	left := t.recordValue(n.children[0], offsets)
+	right := t.recordValue(n.children[1], offsets)
 
 	maxRecord := 1 << t.recordSize
 	if left >= maxRecord || right >= maxRecord {
@@ -678,5 +1020,9 @@ func (t *Tree) writeMetadata(dw *dataWriter) (int64, error) {
 		"node_count":                  mmdbtype.Uint32(t.nodeCount),
 		"record_size":                 mmdbtype.Uint16(t.recordSize),
 	}
+	// The keys were checked against reservedMetadataKeys in New.
+	for k, v := range t.extraMetadata {
+		metadata[k] = v
+	}
 	return metadata.WriteTo(dw)
 }
@@ -2,8 +2,10 @@ package mmdbwriter
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"math/big"
+	"math/rand"
 	"net"
 	"os"
 	"testing"
@@ -519,7 +521,7 @@ func TestTreeInsertAndGet(t *testing.T) {
 						}
 					}
 
-					tree.finalize()
+					require.NoError(t, tree.Finalize())
 
 					for _, get := range test.gets {
 						network, value := tree.Get(net.ParseIP(get.ip))
@@ -645,7 +647,370 @@ func TestInsertFunc_RemovalAndLaterInsert(t *testing.T) {
 	assert.Nil(t, recValue)
 }
 
+func TestTreeGetIPv4Forms(t *testing.T) {
+	value := mmdbtype.String("value")
+	for _, ipVersion := range []int{4, 6} {
+		tree, err := New(Options{IPVersion: ipVersion})
+		require.NoError(t, err)
+		_, network, err := net.ParseCIDR("1.1.1.0/24")
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(network, value))
+
+		for _, ip := range []net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("1.1.1.1").To4()} {
+			recNetwork, recValue := tree.Get(ip)
+			assert.Equal(t, "1.1.1.0/24", recNetwork.String(), "IPv%d tree, %d byte IP", ipVersion, len(ip))
+			assert.Equal(t, value, recValue, "IPv%d tree, %d byte IP", ipVersion, len(ip))
+		}
+
+		if ipVersion == 4 {
+			recNetwork, recValue := tree.Get(net.ParseIP("2001:db8::1"))
+			assert.Equal(t, "::/0", recNetwork.String())
+			assert.Nil(t, recValue)
+		}
+	}
+
+	tree, err := New(Options{DisableIPv4Aliasing: true, IncludeReservedNetworks: true})
+	require.NoError(t, err)
+	_, network, err := net.ParseCIDR("::/64")
+	require.NoError(t, err)
+	require.NoError(t, tree.Insert(network, value))
+
+	recNetwork, recValue := tree.Get(net.ParseIP("1.1.1.1").To4())
+	assert.Equal(t, "::/64", recNetwork.String())
+	assert.Equal(t, value, recValue)
+}
+
 func s2ip(v string) *any { //nolint:gocritic // test
 	i := any(v)
 	return &i
 }
+
+func TestTreeClone(t *testing.T) {
+	base, err := New(
+		Options{
+			DatabaseType: "mmdbwriter-test",
+			Description:  map[string]string{"en": "Test database"},
+		},
+	)
+	require.NoError(t, err)
+
+	for i, network := range []string{"1.1.1.0/24", "2.2.0.0/16", "2003::/16"} {
+		_, ipNet, err := net.ParseCIDR(network)
+		require.NoError(t, err)
+		require.NoError(
+			t,
+			base.Insert(ipNet, mmdbtype.Map{"value": mmdbtype.Uint32(i)}),
+		)
+	}
+
+	baseBuf := &bytes.Buffer{}
+	_, err = base.WriteTo(baseBuf)
+	require.NoError(t, err)
+
+	clone := base.Clone()
+
+	_, ipNet, err := net.ParseCIDR("1.1.1.128/25")
+	require.NoError(t, err)
+	require.NoError(t, clone.InsertFunc(ipNet, inserter.Remove))
+
+	_, ipNet, err = net.ParseCIDR("2.2.2.0/24")
+	require.NoError(t, err)
+	require.NoError(t, clone.Insert(ipNet, mmdbtype.String("clone")))
+
+	// Removing all references to a value in the clone must not remove it
+	// from the base tree's data map.
+	_, ipNet, err = net.ParseCIDR("2003::/16")
+	require.NoError(t, err)
+	require.NoError(t, clone.InsertFunc(ipNet, inserter.Remove))
+
+	_, value := base.Get(net.ParseIP("1.1.1.200"))
+	assert.Equal(t, mmdbtype.Map{"value": mmdbtype.Uint32(0)}, value)
+	_, value = base.Get(net.ParseIP("2.2.2.2"))
+	assert.Equal(t, mmdbtype.Map{"value": mmdbtype.Uint32(1)}, value)
+	_, value = base.Get(net.ParseIP("2003::1"))
+	assert.Equal(t, mmdbtype.Map{"value": mmdbtype.Uint32(2)}, value)
+
+	_, value = clone.Get(net.ParseIP("1.1.1.200"))
+	assert.Nil(t, value)
+	_, value = clone.Get(net.ParseIP("2.2.2.2"))
+	assert.Equal(t, mmdbtype.String("clone"), value)
+	_, value = clone.Get(net.ParseIP("2003::1"))
+	assert.Nil(t, value)
+
+	afterBuf := &bytes.Buffer{}
+	_, err = base.WriteTo(afterBuf)
+	require.NoError(t, err)
+	assert.Equal(t, baseBuf.Bytes(), afterBuf.Bytes(), "base tree is unchanged")
+
+	cloneBuf := &bytes.Buffer{}
+	_, err = clone.WriteTo(cloneBuf)
+	require.NoError(t, err)
+
+	checkMMDB(
+		t,
+		cloneBuf,
+		[]testGet{
+			{
+				ip:                  "2.2.2.2",
+				expectedNetwork:     "2.2.2.0/24",
+				expectedLookupValue: s2ip("clone"),
+			},
+			{
+				// Aliases in the clone point at the clone's IPv4 subtree.
+				ip:                  "2002:202:202::",
+				expectedNetwork:     "2002:202:200::/40",
+				expectedLookupValue: s2ip("clone"),
+			},
+		},
+		"MMDB lookups on cloned tree",
+	)
+}
+
+func TestWriteToAndLoadContext(t *testing.T) {
+	var progress []Progress
+	tree, err := New(
+		Options{
+			DatabaseType: "mmdbwriter-test",
+			Description:  map[string]string{"en": "Test database"},
+			Progress:     func(p Progress) { progress = append(progress, p) },
+		},
+	)
+	require.NoError(t, err)
+
+	for i := 0; i < 10_000; i++ {
+		ip := net.IPv4(1, byte(i>>8), byte(i), 0).To4()
+		require.NoError(
+			t,
+			tree.Insert(
+				&net.IPNet{IP: ip, Mask: net.CIDRMask(24, 32)},
+				mmdbtype.Uint32(i),
+			),
+		)
+	}
+
+	buf := &bytes.Buffer{}
+	numBytes, err := tree.WriteToContext(context.Background(), buf)
+	require.NoError(t, err)
+
+	require.Greater(t, len(progress), 1, "progress reported during write")
+	last := progress[len(progress)-1]
+	assert.Equal(t, tree.nodeCount, last.NodesWritten)
+	assert.Equal(t, numBytes, last.BytesWritten)
+	for i := 1; i < len(progress); i++ {
+		assert.GreaterOrEqual(t, progress[i].NodesWritten, progress[i-1].NodesWritten)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = tree.WriteToContext(ctx, &bytes.Buffer{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	f, err := os.CreateTemp("", "mmdbwriter")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, os.Remove(f.Name())) }()
+	_, err = f.Write(buf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	_, err = LoadContext(ctx, f.Name(), Options{})
+	assert.ErrorIs(t, err, context.Canceled)
+
+	progress = nil
+	_, err = LoadContext(
+		context.Background(),
+		f.Name(),
+		Options{Progress: func(p Progress) { progress = append(progress, p) }},
+	)
+	require.NoError(t, err)
+	require.NotEmpty(t, progress)
+	assert.Equal(t, 10_000, progress[len(progress)-1].NetworksLoaded)
+}
+
+func TestReproducible(t *testing.T) {
+	_, err := New(Options{Reproducible: true})
+	assert.EqualError(t, err, "BuildEpoch must be set when Reproducible is enabled")
+
+	tree, err := New(Options{Reproducible: true, BuildEpoch: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), tree.buildEpoch)
+}
+
+func TestOutputIndependentOfInsertionOrder(t *testing.T) {
+	type insert struct {
+		network *net.IPNet
+		value   mmdbtype.DataType
+	}
+	var inserts []insert
+	for i := 0; i < 300; i++ {
+		// Non-overlapping networks of varying sizes with a small number of
+		// distinct values so that some adjacent networks are merged.
+		network := &net.IPNet{
+			IP:   net.IPv4(1, byte(i>>6), byte(i<<2), 0).To4(),
+			Mask: net.CIDRMask(22+i%3, 32),
+		}
+		inserts = append(inserts, insert{
+			network: network,
+			value: mmdbtype.Map{
+				"a": mmdbtype.Uint32(i % 3 / 2),
+				"b": mmdbtype.Slice{
+					mmdbtype.Map{
+						"c": mmdbtype.String("x"),
+						"d": mmdbtype.Uint32(i % 4),
+					},
+				},
+			},
+		})
+	}
+
+	var expected []byte
+	for seed := int64(0); seed < 10; seed++ {
+		tree, err := New(
+			Options{
+				BuildEpoch:   1,
+				DatabaseType: "mmdbwriter-test",
+				Description: map[string]string{
+					"en": "Test database",
+					"de": "Testdatenbank",
+					"fr": "Base de données de test",
+				},
+			},
+		)
+		require.NoError(t, err)
+
+		for _, i := range rand.New(rand.NewSource(seed)).Perm(len(inserts)) { //nolint:gosec // test
+			require.NoError(t, tree.Insert(inserts[i].network, inserts[i].value))
+		}
+
+		buf := &bytes.Buffer{}
+		_, err = tree.WriteTo(buf)
+		require.NoError(t, err)
+
+		if expected == nil {
+			expected = buf.Bytes()
+			continue
+		}
+		assert.Equal(t, expected, buf.Bytes(), "output for insertion order seed %d", seed)
+	}
+}
+
+func TestMapKeyOrder(t *testing.T) {
+	value := mmdbtype.Map{
+		"city":    mmdbtype.Map{"name": mmdbtype.String("Berlin")},
+		"country": mmdbtype.Map{"name": mmdbtype.String("Germany")},
+	}
+
+	var expected any = map[string]any{
+		"city":    map[string]any{"name": "Berlin"},
+		"country": map[string]any{"name": "Germany"},
+	}
+
+	for _, optimize := range []bool{false, true} {
+		tree, err := New(
+			Options{
+				DatabaseType:        "mmdbwriter-test",
+				Description:         map[string]string{"en": "Test database"},
+				MapKeyOrder:         mmdbtype.PriorityKeyOrder("country"),
+				OptimizeDataSection: optimize,
+			},
+		)
+		require.NoError(t, err)
+
+		_, network, err := net.ParseCIDR("1.1.1.0/24")
+		require.NoError(t, err)
+		require.NoError(t, tree.Insert(network, value))
+
+		buf := &bytes.Buffer{}
+		_, err = tree.WriteTo(buf)
+		require.NoError(t, err)
+
+		assert.Less(
+			t,
+			bytes.Index(buf.Bytes(), []byte("Germany")),
+			bytes.Index(buf.Bytes(), []byte("Berlin")),
+			"country is written before city",
+		)
+
+		checkMMDB(
+			t,
+			buf,
+			[]testGet{
+				{
+					ip:                  "1.1.1.1",
+					expectedNetwork:     "1.1.1.0/24",
+					expectedLookupValue: &expected,
+				},
+			},
+			"MapKeyOrder",
+		)
+	}
+}
+
+func TestTrimNames(t *testing.T) {
+	country := mmdbtype.Map{
+		"iso_code": mmdbtype.String("DE"),
+		"names": mmdbtype.Map{
+			"de": mmdbtype.String("Deutschland"),
+			"en": mmdbtype.String("Germany"),
+		},
+	}
+	TrimNames(country)
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"iso_code": mmdbtype.String("DE"),
+			"names":    mmdbtype.Map{"en": mmdbtype.String("Germany")},
+		},
+		country,
+	)
+
+	// Values that cannot be trimmed are left unchanged rather than causing
+	// a panic.
+	for _, v := range []any{
+		nil,
+		mmdbtype.String("DE"),
+		mmdbtype.Slice{mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String("Bavaria")}}},
+		mmdbtype.Map{"iso_code": mmdbtype.String("DE")},
+		mmdbtype.Map{"names": mmdbtype.String("Germany")},
+		mmdbtype.Map{"names": mmdbtype.Map{"de": mmdbtype.String("Deutschland")}},
+	} {
+		var expected any
+		if d, ok := v.(mmdbtype.DataType); ok {
+			expected = d.Copy()
+		}
+		assert.NotPanics(t, func() { TrimNames(v) }, "%v", v)
+		assert.Equal(t, expected, v)
+	}
+}
+
+func TestTrimRVNames(t *testing.T) {
+	names := mmdbtype.Map{
+		"de": mmdbtype.String("Deutschland"),
+		"en": mmdbtype.String("Germany"),
+	}
+	rv := mmdbtype.Map{
+		"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE"), "names": names},
+		"subdivisions": mmdbtype.Slice{
+			mmdbtype.Map{"names": names},
+			mmdbtype.Map{"names": mmdbtype.Map{"de": mmdbtype.String("Bayern")}},
+		},
+		"traits": mmdbtype.Map{"names": names},
+	}
+
+	TrimRVNames(rv)
+
+	en := mmdbtype.Map{"en": mmdbtype.String("Germany")}
+	assert.Equal(
+		t,
+		mmdbtype.Map{
+			"country": mmdbtype.Map{"iso_code": mmdbtype.String("DE"), "names": en},
+			"subdivisions": mmdbtype.Slice{
+				mmdbtype.Map{"names": en},
+				mmdbtype.Map{"names": mmdbtype.Map{"de": mmdbtype.String("Bayern")}},
+			},
+			"traits": mmdbtype.Map{"names": names},
+		},
+		rv,
+	)
+	assert.Len(t, names, 2, "shared values are not modified")
+}